@@ -0,0 +1,305 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"hash"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	oidAuthenticatedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 2}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidKeyWrapAES128 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	oidKeyWrapAES192 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	oidKeyWrapAES256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+)
+
+// ErrMACMismatch is returned when the message authentication code of an
+// AuthenticatedData does not match the computed one
+var ErrMACMismatch = xerrors.New("pkcs7: message authentication code mismatch")
+
+type authenticatedData struct {
+	Version          int
+	OriginatorInfo   asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos   []asn1.RawValue `asn1:"set"`
+	MACAlgorithm     pkix.AlgorithmIdentifier
+	DigestAlgorithm  pkix.AlgorithmIdentifier `asn1:"optional,tag:1"`
+	ContentInfo      contentInfo
+	AuthAttributes   []attribute `asn1:"optional,tag:2"`
+	MAC              []byte
+	UnauthAttributes []attribute `asn1:"optional,tag:3"`
+}
+
+func parseAuthenticatedData(data []byte) (*PKCS7, error) {
+	var ad authenticatedData
+	if _, err := asn1.Unmarshal(data, &ad); err != nil {
+		return nil, err
+	}
+	content, err := ad.ContentInfo.unwrap()
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS7{
+		Content: content,
+		version: ad.Version,
+		raw:     ad,
+	}, nil
+}
+
+func getHashForMACOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidHMACWithSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidHMACWithSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidHMACWithSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidHMACWithSHA512):
+		return crypto.SHA512, nil
+	}
+	return crypto.Hash(0), xerrors.Errorf("getting hash for MAC OID: %w", ErrUnsupportedAlgorithm)
+}
+
+// VerifyMAC checks the HMAC of an AuthenticatedData message using the
+// message authentication key. When authenticated attributes are present the
+// content digest is checked against the message-digest attribute and the MAC
+// is computed over the attributes, otherwise over the content itself.
+func (p7 *PKCS7) VerifyMAC(key []byte) error {
+	ad, ok := p7.raw.(authenticatedData)
+	if !ok {
+		return xerrors.New("pkcs7: payload is not authenticatedData content")
+	}
+	macHash, err := getHashForMACOID(ad.MACAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(macHash.New, key)
+	if len(ad.AuthAttributes) > 0 {
+		hash, err := getHashForOID(ad.DigestAlgorithm.Algorithm)
+		if err != nil {
+			return err
+		}
+		var digest []byte
+		if err = unmarshalAttribute(ad.AuthAttributes, oidAttributeMessageDigest, &digest); err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(p7.Content)
+		if computed := h.Sum(nil); !hmac.Equal(digest, computed) {
+			return &MessageDigestMismatchError{
+				ExpectedDigest: digest,
+				ActualDigest:   computed,
+			}
+		}
+		attrs, err := marshalAttributes(ad.AuthAttributes)
+		if err != nil {
+			return err
+		}
+		mac.Write(attrs)
+	} else {
+		mac.Write(p7.Content)
+	}
+	if !hmac.Equal(mac.Sum(nil), ad.MAC) {
+		return ErrMACMismatch
+	}
+	return nil
+}
+
+type kekRecipientInfo struct {
+	Version                int
+	KEKIdentifier          kekIdentifier
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type kekIdentifier struct {
+	KeyIdentifier []byte
+}
+
+func getKeyWrapOIDForKEK(kek []byte) (asn1.ObjectIdentifier, error) {
+	switch len(kek) {
+	case 16:
+		return oidKeyWrapAES128, nil
+	case 24:
+		return oidKeyWrapAES192, nil
+	case 32:
+		return oidKeyWrapAES256, nil
+	}
+	return nil, xerrors.Errorf("pkcs7: invalid key encryption key length %d", len(kek))
+}
+
+// DecryptMACKey unwraps the message authentication key of an
+// AuthenticatedData message delivered to the pre-shared key encryption key
+// identified by keyID
+func (p7 *PKCS7) DecryptMACKey(keyID, kek []byte) ([]byte, error) {
+	ad, ok := p7.raw.(authenticatedData)
+	if !ok {
+		return nil, xerrors.New("pkcs7: payload is not authenticatedData content")
+	}
+	for _, ri := range ad.RecipientInfos {
+		if ri.Class != asn1.ClassContextSpecific || ri.Tag != 2 {
+			continue
+		}
+		var kri kekRecipientInfo
+		if _, err := asn1.UnmarshalWithParams(ri.FullBytes, &kri, "tag:2"); err != nil {
+			return nil, xerrors.Errorf("unmarshaling KEK recipient info: %w", err)
+		}
+		if !bytes.Equal(kri.KEKIdentifier.KeyIdentifier, keyID) {
+			continue
+		}
+		oid, err := getKeyWrapOIDForKEK(kek)
+		if err != nil {
+			return nil, err
+		}
+		if !oid.Equal(kri.KeyEncryptionAlgorithm.Algorithm) {
+			return nil, xerrors.Errorf("unwrapping MAC key: %w", ErrUnsupportedAlgorithm)
+		}
+		return aesKeyUnwrap(kek, kri.EncryptedKey)
+	}
+	return nil, xerrors.New("pkcs7: no recipient for provided key identifier")
+}
+
+// AuthenticatorConfig are optional values used when producing AuthenticatedData
+type AuthenticatorConfig struct {
+	// AuthenticatedAttributes enables authenticated attributes, in which case
+	// the MAC is computed over the attributes including the content digest
+	AuthenticatedAttributes      bool
+	ExtraAuthenticatedAttributes []Attribute
+}
+
+// AuthenticatedData is an opaque data structure for creating stream
+// HMAC-SHA256 authenticated data payloads
+type AuthenticatedData struct {
+	w      *berWriter
+	ad     authenticatedData
+	config AuthenticatorConfig
+	key    []byte
+}
+
+// NewAuthenticator creates stream AuthenticatedData producer. The MAC key is
+// generated randomly and must be delivered to recipients with AddKEKRecipient.
+func NewAuthenticator(w io.Writer, config AuthenticatorConfig) (*AuthenticatedData, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, xerrors.Errorf("generating MAC key: %w", err)
+	}
+	res := &AuthenticatedData{
+		w:      &berWriter{Writer: w},
+		config: config,
+		key:    key,
+	}
+	res.ad.MACAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256}
+	if config.AuthenticatedAttributes {
+		res.ad.DigestAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+	}
+	return res, nil
+}
+
+// AddKEKRecipient wraps the MAC key with the pre-shared AES key encryption key
+// identified by keyID
+func (ad *AuthenticatedData) AddKEKRecipient(keyID, kek []byte) error {
+	oid, err := getKeyWrapOIDForKEK(kek)
+	if err != nil {
+		return err
+	}
+	wrapped, err := aesKeyWrap(kek, ad.key)
+	if err != nil {
+		return err
+	}
+	kri := kekRecipientInfo{
+		Version:                4,
+		KEKIdentifier:          kekIdentifier{KeyIdentifier: keyID},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+		EncryptedKey:           wrapped,
+	}
+	data, err := asn1.MarshalWithParams(kri, "tag:2")
+	if err != nil {
+		return xerrors.Errorf("marshaling KEK recipient info: %w", err)
+	}
+	ad.ad.RecipientInfos = append(ad.ad.RecipientInfos, asn1.RawValue{FullBytes: data})
+	return nil
+}
+
+func (ad *AuthenticatedData) authenticate(r io.Reader, size int) continuation {
+	return ad.w.explicit(4, size, func(class int, constructed bool, _ int, _ int) (err error) {
+		mac := hmac.New(crypto.SHA256.New, ad.key)
+		var h hash.Hash = mac
+		if ad.config.AuthenticatedAttributes {
+			h = crypto.SHA256.New()
+		}
+		if _, err = io.Copy(ad.w, io.TeeReader(io.LimitReader(r, int64(size)), h)); err != nil {
+			return
+		}
+		if ad.config.AuthenticatedAttributes {
+			attrs := &attributes{}
+			attrs.Add(oidAttributeContentType, oidData)
+			attrs.Add(oidAttributeMessageDigest, h.Sum(nil))
+			for _, attr := range ad.config.ExtraAuthenticatedAttributes {
+				attrs.Add(attr.Type, attr.Value)
+			}
+			if ad.ad.AuthAttributes, err = attrs.ForMarshaling(); err != nil {
+				return err
+			}
+			attrBytes, err := marshalAttributes(ad.ad.AuthAttributes)
+			if err != nil {
+				return err
+			}
+			mac.Write(attrBytes)
+		}
+		ad.ad.MAC = mac.Sum(nil)
+		return
+	})
+}
+
+// AuthenticateFrom writes AuthenticatedData with content of known size read
+// from r, computing the MAC as the content streams
+func (ad *AuthenticatedData) AuthenticateFrom(r io.Reader, size int) error {
+	if len(ad.ad.RecipientInfos) == 0 {
+		return xerrors.New("pkcs7: no recipients for authenticated data")
+	}
+	w := ad.w
+	seq := []continuation{
+		w.object(ad.ad.Version, ""),
+		w.object(ad.ad.RecipientInfos, "set"),
+		w.object(ad.ad.MACAlgorithm, ""),
+	}
+	if ad.config.AuthenticatedAttributes {
+		seq = append(seq, w.object(ad.ad.DigestAlgorithm, "tag:1"))
+	}
+	seq = append(seq,
+		w.oid(
+			oidData,
+			w.optional(0,
+				ad.authenticate(r, size),
+			),
+		),
+		func(class int, constructed bool, tag int, length int) error {
+			if len(ad.ad.AuthAttributes) == 0 {
+				return nil
+			}
+			return w.object(ad.ad.AuthAttributes, "tag:2")(class, constructed, tag, length)
+		},
+		func(class int, constructed bool, tag int, length int) error {
+			return w.object(ad.ad.MAC, "")(class, constructed, tag, length)
+		},
+	)
+	return w.writeBER(
+		w.oid(oidAuthenticatedData,
+			w.optional(0,
+				w.sequence(seq...),
+			),
+		),
+	)
+}
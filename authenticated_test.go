@@ -0,0 +1,116 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func marshalTestAuthenticatedData(content, key []byte, withAttributes bool) ([]byte, error) {
+	inner, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	ad := authenticatedData{
+		MACAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256},
+		ContentInfo: contentInfo{
+			ContentType: oidData,
+			Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: inner, IsCompound: true},
+		},
+	}
+	mac := hmac.New(crypto.SHA256.New, key)
+	if withAttributes {
+		h := crypto.SHA256.New()
+		h.Write(content)
+		attrs := &attributes{}
+		attrs.Add(oidAttributeContentType, oidData)
+		attrs.Add(oidAttributeMessageDigest, h.Sum(nil))
+		if ad.AuthAttributes, err = attrs.ForMarshaling(); err != nil {
+			return nil, err
+		}
+		ad.DigestAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+		attrBytes, err := marshalAttributes(ad.AuthAttributes)
+		if err != nil {
+			return nil, err
+		}
+		mac.Write(attrBytes)
+	} else {
+		mac.Write(content)
+	}
+	ad.MAC = mac.Sum(nil)
+	data, err := asn1.Marshal(ad)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidAuthenticatedData,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: data, IsCompound: true},
+	})
+}
+
+func TestVerifyMAC(t *testing.T) {
+	content := []byte("Hello Authenticated World")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, withAttributes := range []bool{false, true} {
+		data, err := marshalTestAuthenticatedData(content, key, withAttributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p7, err := Parse(data)
+		if err != nil {
+			t.Fatalf("Cannot parse authenticated data: %s", err)
+		}
+		if string(p7.Content) != string(content) {
+			t.Errorf("Content does not match.\n\tExpected: %s\n\tActual: %s", content, p7.Content)
+		}
+		if err = p7.VerifyMAC(key); err != nil {
+			t.Errorf("Cannot verify MAC (attributes: %v): %s", withAttributes, err)
+		}
+		if err = p7.VerifyMAC([]byte("wrong key")); err != ErrMACMismatch {
+			t.Errorf("expected MAC mismatch for wrong key, got %v", err)
+		}
+	}
+}
+
+func TestAuthenticator_AuthenticateFrom(t *testing.T) {
+	content := make([]byte, 10000)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte("psk-1")
+	kek := []byte("0123456789abcdef")
+	for _, withAttributes := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		ad, err := NewAuthenticator(buf, AuthenticatorConfig{AuthenticatedAttributes: withAttributes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ad.AddKEKRecipient(keyID, kek); err != nil {
+			t.Fatalf("Cannot add recipient: %s", err)
+		}
+		if err = ad.AuthenticateFrom(bytes.NewReader(content), len(content)); err != nil {
+			t.Fatalf("Cannot authenticate content: %+v", err)
+		}
+		p7, err := Parse(buf.Bytes())
+		if err != nil {
+			t.Fatalf("Cannot parse authenticated data: %s", err)
+		}
+		if !bytes.Equal(content, p7.Content) {
+			t.Error("content does not match")
+		}
+		if _, err = p7.DecryptMACKey([]byte("psk-2"), kek); err == nil {
+			t.Error("expected error for unknown key identifier")
+		}
+		key, err := p7.DecryptMACKey(keyID, kek)
+		if err != nil {
+			t.Fatalf("Cannot decrypt MAC key: %s", err)
+		}
+		if err = p7.VerifyMAC(key); err != nil {
+			t.Errorf("Cannot verify MAC (attributes: %v): %s", withAttributes, err)
+		}
+	}
+}
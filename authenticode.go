@@ -0,0 +1,50 @@
+package pkcs7
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"golang.org/x/xerrors"
+)
+
+var oidSpcIndirectData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+type digestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest digestInfo
+}
+
+// AuthenticodeDigest returns the hash of the signed file recorded in the
+// SpcIndirectDataContent of a Microsoft Authenticode signature, together with
+// the hash function used to compute it. Verify checks the signature over the
+// SpcIndirectDataContent, the caller still has to hash the file the
+// Authenticode way and compare the result with the returned digest.
+func (p7 *PKCS7) AuthenticodeDigest() (crypto.Hash, []byte, error) {
+	sd, err := p7.signedData()
+	if err != nil {
+		return crypto.Hash(0), nil, err
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		return crypto.Hash(0), nil, xerrors.Errorf("pkcs7: content type %v is not SpcIndirectDataContent", sd.ContentInfo.ContentType)
+	}
+	var spc spcIndirectDataContent
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &spc); err != nil {
+		return crypto.Hash(0), nil, xerrors.Errorf("pkcs7: parsing SpcIndirectDataContent: %w", err)
+	}
+	hash, err := getHashForOID(spc.MessageDigest.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return crypto.Hash(0), nil, err
+	}
+	return hash, spc.MessageDigest.Digest, nil
+}
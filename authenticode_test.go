@@ -0,0 +1,303 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+)
+
+// marshalTestAuthenticode signs an SpcIndirectDataContent for the file hash
+// the way Authenticode does, digesting the contents octets of the SEQUENCE
+func marshalTestAuthenticode(fileHash []byte) ([]byte, error) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		return nil, err
+	}
+	spc, err := asn1.Marshal(spcIndirectDataContent{
+		Data: spcAttributeTypeAndOptionalValue{
+			Type: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}, // SPC_PE_IMAGE_DATAOBJ
+		},
+		MessageDigest: digestInfo{
+			DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			Digest:          fileHash,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var seq asn1.RawValue
+	if _, err = asn1.Unmarshal(spc, &seq); err != nil {
+		return nil, err
+	}
+	toBeSigned, err := NewSignedData(seq.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	toBeSigned.sd.ContentInfo = contentInfo{
+		ContentType: oidSpcIndirectData,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: spc},
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	return toBeSigned.Finish()
+}
+
+func TestAuthenticode(t *testing.T) {
+	fileHash := sha256.Sum256([]byte("MZ fake portable executable"))
+	signed, err := marshalTestAuthenticode(fileHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p7.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	hash, digest, err := p7.AuthenticodeDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != crypto.SHA256 || !bytes.Equal(digest, fileHash[:]) {
+		t.Errorf("expected SHA256 %x, got %v %x", fileHash, hash, digest)
+	}
+
+	fixture := UnmarshalTestFixture(SignedTestFixture)
+	if p7, err = Parse(fixture.Input); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = p7.AuthenticodeDigest(); err == nil {
+		t.Error("expected error for non Authenticode content")
+	}
+}
+
+func TestAuthenticodeSignedPE(t *testing.T) {
+	block, _ := pem.Decode([]byte(AuthenticodeTestFixture))
+	p7, err := Parse(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p7.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	hash, digest, err := p7.AuthenticodeDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the SHA-256 Authenticode hash of the PE file, computed separately
+	// over the image without its checksum, certificate table entry and
+	// certificate table
+	want, _ := hex.DecodeString("53f4e98dad0a9a741a1fc0772625190ccc8a0e620ed7768331b28802f5154f11")
+	if hash != crypto.SHA256 || !bytes.Equal(digest, want) {
+		t.Errorf("expected SHA256 %x, got %v %x", want, hash, digest)
+	}
+	// as stored in the certificate table, padded to a multiple of 8 bytes
+	if _, err = Parse(append(block.Bytes, 0, 0, 0)); err != nil {
+		t.Errorf("padded signature: %v", err)
+	}
+}
+
+// AuthenticodeTestFixture is the Authenticode signature taken from the
+// certificate table of Microsoft.Extensions.Logging.Console.dll, a reference
+// assembly of the ASP.NET Core 3.1.10 targeting pack signed by Microsoft,
+// without the padding of the WIN_CERTIFICATE entry
+var AuthenticodeTestFixture = `
+-----BEGIN PKCS7-----
+MIIjaQYJKoZIhvcNAQcCoIIjWjCCI1YCAQExDzANBglghkgBZQMEAgEFADBcBgor
+BgEEAYI3AgEEoE4wTDAXBgorBgEEAYI3AgEPMAkDAQCgBKICgAAwMTANBglghkgB
+ZQMEAgEFAAQgU/Tpja0KmnQaH8B3JiUZDMyKDmIO13aDMbKIAvUVTxGggg2BMIIF
+/zCCA+egAwIBAgITMwAAAYdyF3IVWUDHCQAAAAABhzANBgkqhkiG9w0BAQsFADB+
+MQswCQYDVQQGEwJVUzETMBEGA1UECBMKV2FzaGluZ3RvbjEQMA4GA1UEBxMHUmVk
+bW9uZDEeMBwGA1UEChMVTWljcm9zb2Z0IENvcnBvcmF0aW9uMSgwJgYDVQQDEx9N
+aWNyb3NvZnQgQ29kZSBTaWduaW5nIFBDQSAyMDExMB4XDTIwMDMwNDE4Mzk0N1oX
+DTIxMDMwMzE4Mzk0N1owdDELMAkGA1UEBhMCVVMxEzARBgNVBAgTCldhc2hpbmd0
+b24xEDAOBgNVBAcTB1JlZG1vbmQxHjAcBgNVBAoTFU1pY3Jvc29mdCBDb3Jwb3Jh
+dGlvbjEeMBwGA1UEAxMVTWljcm9zb2Z0IENvcnBvcmF0aW9uMIIBIjANBgkqhkiG
+9w0BAQEFAAOCAQ8AMIIBCgKCAQEAzrfJC3Oz90+zCiIaLmB3sDBZp6vAMruxToWQ
+kGm1cAadlUuFsgdkHuE0AU/Ggc5wDQxD4xyjXT0/F8+XDWpYulx3n0vIv1l7RdL0
+rD/DRL+pgR7gNqdX8NsAfxdHR7Cdxn2eXNLDyY5JbImKj8OfcSeeJDPdSDoIjtjl
+M4zQJYz4m4wlnx+1M0NUzx3OHcHopbPBhCK2wUW+yFsIjmy9do1k+GIe9TUILyfR
+Z+vlIQ/cdrpN3S4/OL8LdTbhUIrSicSFdH1bETUd2m0FTi6qQ7oG69EszS+qPMcz
+hy+Tl4hhsIOnpIlwNf9l12O8lRXN/bZXnQ7WY0ozW3sdc88ElwIDAQABo4IBfjCC
+AXowHwYDVR0lBBgwFgYKKwYBBAGCN0wIAQYIKwYBBQUHAwMwHQYDVR0OBBYEFIaL
++GcjvemsZCXTI6c7ts1VziXLMFAGA1UdEQRJMEekRTBDMSkwJwYDVQQLEyBNaWNy
+b3NvZnQgT3BlcmF0aW9ucyBQdWVydG8gUmljbzEWMBQGA1UEBRMNMjMwMDEyKzQ1
+ODM4NTAfBgNVHSMEGDAWgBRIbmTlUAXTgqoXNzcitW2oynUClTBUBgNVHR8ETTBL
+MEmgR6BFhkNodHRwOi8vd3d3Lm1pY3Jvc29mdC5jb20vcGtpb3BzL2NybC9NaWND
+b2RTaWdQQ0EyMDExXzIwMTEtMDctMDguY3JsMGEGCCsGAQUFBwEBBFUwUzBRBggr
+BgEFBQcwAoZFaHR0cDovL3d3dy5taWNyb3NvZnQuY29tL3BraW9wcy9jZXJ0cy9N
+aWNDb2RTaWdQQ0EyMDExXzIwMTEtMDctMDguY3J0MAwGA1UdEwEB/wQCMAAwDQYJ
+KoZIhvcNAQELBQADggIBAIsZskuhOr6a1g/ShTSAfRuc8jLiI2QDrlCdRCv1ZYOh
+W92R1441MAEyiHF2xbhQulq+Cja1OA2P7AVapmm+QAv43t26VKY7caRMqlKrT3N9
+MBIP6zvb5ipqiqCz09+7L3NjVQZhjZfvOajuH1f8OwseydAW6pNfSnETXY7eniqE
+50zxwR5VR0CB2aTMWnGxTgJCa6gFZGGXc+4pDV08VfhkW9+rQuAcjDcRNgxe7xXb
+2omT9AlWeQcidoAIVzHSvfrrMc1ZPdd6inXtTgLlnb/q53apACJvH1JUZ6+LGkgo
+O3CG1MAgn9desFCexLiQ4NLx3soZwnh5wW8h90WZBxItqH5n4JxSEiWQ3TAHlWRl
+TodtCaedFwc6qJKT83mes3Nf4MiCzcolYBPkT5I51ELIXdX9TzIJ97Z7Ngs+2yYl
+VGqhDt5/akRYMuSbi2nulMHhnwHjqN3YC2cYpCs2LN4QzGhLSavCD+9XF+0F3upZ
+zJl1Px3X89qfPe2XfpFPr2byiN3MC37lUICtkWds/inNyt3UT89q18nCuVwrkWZr
+xmm/1m62Ygu8CUGqYAaHZbTCORjHRawYPSHhe/6z+BKlUF3irXr05WV46bjYYY7k
+ftgzLf3Vrn416YlvdW6N2h+hGozgC15qMYJbQqdSu4a0uoJrL4/eHC0X+dEEOFPE
+MIIHejCCBWKgAwIBAgIKYQ6Q0gAAAAAAAzANBgkqhkiG9w0BAQsFADCBiDELMAkG
+A1UEBhMCVVMxEzARBgNVBAgTCldhc2hpbmd0b24xEDAOBgNVBAcTB1JlZG1vbmQx
+HjAcBgNVBAoTFU1pY3Jvc29mdCBDb3Jwb3JhdGlvbjEyMDAGA1UEAxMpTWljcm9z
+b2Z0IFJvb3QgQ2VydGlmaWNhdGUgQXV0aG9yaXR5IDIwMTEwHhcNMTEwNzA4MjA1
+OTA5WhcNMjYwNzA4MjEwOTA5WjB+MQswCQYDVQQGEwJVUzETMBEGA1UECBMKV2Fz
+aGluZ3RvbjEQMA4GA1UEBxMHUmVkbW9uZDEeMBwGA1UEChMVTWljcm9zb2Z0IENv
+cnBvcmF0aW9uMSgwJgYDVQQDEx9NaWNyb3NvZnQgQ29kZSBTaWduaW5nIFBDQSAy
+MDExMIICIjANBgkqhkiG9w0BAQEFAAOCAg8AMIICCgKCAgEAq/D6chAcLq3YbqqC
+EE00uvK2WCGfQhsqa+laUKq4BjgaBEm6f8MMHt03a8YS2AvwOMKZBrDIOdUBFDFC
+04kNeWSHfpRgJGyvnkmc6Whe0t+bU7IKLMOv2akrrnoJr9eWWcpgGgXpZnboMlIm
+Ei/nqwhQz7NEt13YxC4Ddato88tt8zpcoRb0RrrgOGSsbmQ1eKagYw8t00CT+OPe
+Bw3VXHmlSSnnDb6gE3e+lD3v++MrWhAfTVYoonpy4BI6t0le2O3tQ5GD2Xuye4Yb
+2T6xjF3oiU+EGvKhL1nkkDstrjNYxbc+/jLTswM9sbKvkjh+0p2ALPVOVpEhNSXD
+OW5kf1O6nA+tGSOEy/S6A4aN91/w0FK/jJSHvMAhdCVfGCi2zCcoOCWYOUo2z3yx
+kq4cI6epZuxhH2rhKEmdX4jiJV3TIUs+UsS1Vz8kA/DRelsv1SPjcF0PUUZ3s/gA
+4bysAoJf28AVs70b1FVL5zmhD+kjSbwYuER8ReTBw3J64HLnJN+/RpnF78IcV9uD
+jexNSTCnq47f7Fufr/zdsGbiwZeBe+3W7UvnSSmnEyimp31ngOaKYnhfsi+E11ec
+XL93KCjx7W3DKI8sj0A3T8HhhUSJxAlMxdSlQy90lfdu+HggWCwTXWCVmj5PM4Ta
+sIgX3p5O9JawvEagbJjS4NaIjAsCAwEAAaOCAe0wggHpMBAGCSsGAQQBgjcVAQQD
+AgEAMB0GA1UdDgQWBBRIbmTlUAXTgqoXNzcitW2oynUClTAZBgkrBgEEAYI3FAIE
+DB4KAFMAdQBiAEMAQTALBgNVHQ8EBAMCAYYwDwYDVR0TAQH/BAUwAwEB/zAfBgNV
+HSMEGDAWgBRyLToCMZBDuRQFTuHqp8cx0SOJNDBaBgNVHR8EUzBRME+gTaBLhklo
+dHRwOi8vY3JsLm1pY3Jvc29mdC5jb20vcGtpL2NybC9wcm9kdWN0cy9NaWNSb29D
+ZXJBdXQyMDExXzIwMTFfMDNfMjIuY3JsMF4GCCsGAQUFBwEBBFIwUDBOBggrBgEF
+BQcwAoZCaHR0cDovL3d3dy5taWNyb3NvZnQuY29tL3BraS9jZXJ0cy9NaWNSb29D
+ZXJBdXQyMDExXzIwMTFfMDNfMjIuY3J0MIGfBgNVHSAEgZcwgZQwgZEGCSsGAQQB
+gjcuAzCBgzA/BggrBgEFBQcCARYzaHR0cDovL3d3dy5taWNyb3NvZnQuY29tL3Br
+aW9wcy9kb2NzL3ByaW1hcnljcHMuaHRtMEAGCCsGAQUFBwICMDQeMiAdAEwAZQBn
+AGEAbABfAHAAbwBsAGkAYwB5AF8AcwB0AGEAdABlAG0AZQBuAHQALiAdMA0GCSqG
+SIb3DQEBCwUAA4ICAQBn8oalmOBUeRou09h0ZyKbC5YR4WOSmUKWfdJ5DJDBZV8u
+LD74w3LRbYP+vj/oCso7v0epo/Np22O/IjWll11lhJB9i0ZQVdgMknzSGksc8zxC
+i1LQsP1r4z4HLimb5j0bpdS1HXeUOeLpZMlEPXh6I/MTfaaQdION9MsmAkYqwooQ
+u6SpBQyb7Wj6aC6VoCo/KmtYSWMfCWluWpiW5IP0wI/zRive/DvQvTXvbiWu5a8n
+7dDd8w6vmSiXmE0OPQvyCInWH8MyGOLwxS3OW560STkKxgrCxq2u5bLZ2xWIUUVY
+ODJxJxp/sfQn+N4sOiBpmLJZiWhub6e3dMNABQamASooPoI/E01mC8CzTfXhj38c
+bxV9Rad25UAqZaPDXVJihsMdYzaXht/a8/jyFqGaJ+HNpZfQ7l1jQeNbB5yHPgZ3
+BtEGsXUfFL5hYbXw3MYbBL7fQccOKO7eZS/sl/ahXJbYANahRr1Z85elCUtIEJmA
+H9AAKcWxm6U/RXceNcbSoqKfenoi+kiVH6v7RyOA9Z74v2u3S5fi63V4GuzqN5l5
+GEv/1rMjaHXmr/r8i+sLgOppO6/8MO0ETI7f33VtY5E90Z1WTk+/gFcioXgRMiF6
+70EKsT/7qMykXcGhiJtXcVZOSEXAQsmbdlsKgEhr/Xmfwb1tbWrJUnMTDXpQzTGC
+FVswghVXAgEBMIGVMH4xCzAJBgNVBAYTAlVTMRMwEQYDVQQIEwpXYXNoaW5ndG9u
+MRAwDgYDVQQHEwdSZWRtb25kMR4wHAYDVQQKExVNaWNyb3NvZnQgQ29ycG9yYXRp
+b24xKDAmBgNVBAMTH01pY3Jvc29mdCBDb2RlIFNpZ25pbmcgUENBIDIwMTECEzMA
+AAGHchdyFVlAxwkAAAAAAYcwDQYJYIZIAWUDBAIBBQCgga4wGQYJKoZIhvcNAQkD
+MQwGCisGAQQBgjcCAQQwHAYKKwYBBAGCNwIBCzEOMAwGCisGAQQBgjcCARUwLwYJ
+KoZIhvcNAQkEMSIEIGfVvpwOl3VAYrTK8EPi7508nOGJELF8ahPcCOO3gvAfMEIG
+CisGAQQBgjcCAQwxNDAyoBSAEgBNAGkAYwByAG8AcwBvAGYAdKEagBhodHRwOi8v
+d3d3Lm1pY3Jvc29mdC5jb20wDQYJKoZIhvcNAQEBBQAEggEACmdqWE7CeDaJ6c/A
+KtUbw4m/UHWfEPQYGX4/DcE+els35ziwE1wvfSSlr8jo+mufrUAIckbQoJjcFXAA
+WGhhGvUO37RlsWUSfPf5SsHKSmivfnbjOvcLvsFu/MOqW4vMmUlL9rs6l8irrwR2
+tBooYreaaMPtBr1WLLZs3kVDhktZbW0qpcB6aglcRa9MjiG/bRiag+CkbSvfbtDU
+HZWtz0FzFiBOJpPIi6pAJYYs6Gl1Bb/ggQxXBH3LhUxGft5AfGV3AftU10pg2BX+
+nhh+/cM43rnLxrJiucOJtGiP5+GVRAMN22qhbZsWFGGRC7ZxYN65jGYQhdCCsjFd
+VAnX3qGCEuUwghLhBgorBgEEAYI3AwMBMYIS0TCCEs0GCSqGSIb3DQEHAqCCEr4w
+ghK6AgEDMQ8wDQYJYIZIAWUDBAIBBQAwggFRBgsqhkiG9w0BCRABBKCCAUAEggE8
+MIIBOAIBAQYKKwYBBAGEWQoDATAxMA0GCWCGSAFlAwQCAQUABCAECaUeYnq/kvNZ
+HWDYk88N2xBOM9YuxpF7CO1vZFEHtAIGXz0tP8QZGBMyMDIwMDgyMDIzNTcyMS40
+OTJaMASAAgH0oIHQpIHNMIHKMQswCQYDVQQGEwJVUzELMAkGA1UECBMCV0ExEDAO
+BgNVBAcTB1JlZG1vbmQxHjAcBgNVBAoTFU1pY3Jvc29mdCBDb3Jwb3JhdGlvbjEt
+MCsGA1UECxMkTWljcm9zb2Z0IElyZWxhbmQgT3BlcmF0aW9ucyBMaW1pdGVkMSYw
+JAYDVQQLEx1UaGFsZXMgVFNTIEVTTjozQkQ0LTRCODAtNjlDMzElMCMGA1UEAxMc
+TWljcm9zb2Z0IFRpbWUtU3RhbXAgU2VydmljZaCCDjwwggTxMIID2aADAgECAhMz
+AAABC+T5vo9vTB3QAAAAAAELMA0GCSqGSIb3DQEBCwUAMHwxCzAJBgNVBAYTAlVT
+MRMwEQYDVQQIEwpXYXNoaW5ndG9uMRAwDgYDVQQHEwdSZWRtb25kMR4wHAYDVQQK
+ExVNaWNyb3NvZnQgQ29ycG9yYXRpb24xJjAkBgNVBAMTHU1pY3Jvc29mdCBUaW1l
+LVN0YW1wIFBDQSAyMDEwMB4XDTE5MTAyMzIzMTkxNVoXDTIxMDEyMTIzMTkxNVow
+gcoxCzAJBgNVBAYTAlVTMQswCQYDVQQIEwJXQTEQMA4GA1UEBxMHUmVkbW9uZDEe
+MBwGA1UEChMVTWljcm9zb2Z0IENvcnBvcmF0aW9uMS0wKwYDVQQLEyRNaWNyb3Nv
+ZnQgSXJlbGFuZCBPcGVyYXRpb25zIExpbWl0ZWQxJjAkBgNVBAsTHVRoYWxlcyBU
+U1MgRVNOOjNCRDQtNEI4MC02OUMzMSUwIwYDVQQDExxNaWNyb3NvZnQgVGltZS1T
+dGFtcCBTZXJ2aWNlMIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAlwLV
+nUYxQbjPg9p4VCi1blr/XGXKtf/HpspEAaZQ4ovA6sMAjZw9MyYc+5/eFrVoxbHO
+Si/3RfIClkzER+TFU2uXcQibulbWaG3PrM7TPtCTzOVZnG/+w/gJRRERgJEBhsTv
+2eH8Rx9fxHGf4sFIps2n14wTpSEN0UsVAI/fNJYrgMjQq4/CXbpxkd51Ukb8SbVq
+VGb5SFK2GOCw5iSbBbCPILHIdy63IZj3gZKMbL8u0aSoXDkLU2GnA+PL8+3809nI
+nIiagF8Wbe37YfLIKiolFEQlbkpXFClwV5v9XXGAiqjqFM9mBrtotLeCv19eyVme
+Y3Tdb8as0kGvT+Dx8QIDAQABo4IBGzCCARcwHQYDVR0OBBYEFK0f2eodih6c4JgN
+UERl//dtXt7vMB8GA1UdIwQYMBaAFNVjOlyKMZDzQ3t8RhvFM2hahW1VMFYGA1Ud
+HwRPME0wS6BJoEeGRWh0dHA6Ly9jcmwubWljcm9zb2Z0LmNvbS9wa2kvY3JsL3By
+b2R1Y3RzL01pY1RpbVN0YVBDQV8yMDEwLTA3LTAxLmNybDBaBggrBgEFBQcBAQRO
+MEwwSgYIKwYBBQUHMAKGPmh0dHA6Ly93d3cubWljcm9zb2Z0LmNvbS9wa2kvY2Vy
+dHMvTWljVGltU3RhUENBXzIwMTAtMDctMDEuY3J0MAwGA1UdEwEB/wQCMAAwEwYD
+VR0lBAwwCgYIKwYBBQUHAwgwDQYJKoZIhvcNAQELBQADggEBACboo52p7za0ut3v
+OwitIMCJiPAuCXYcSyz5wOpv6VEl1npfSgmt7feTUTTt+jYHpg8YbJM+61R4lIoG
+9aSXZvkweUoYNg5T4tVIXQk2jeZU1mfqxwBXwyOItoHSjsHcroO95uY2tnanw05d
+g4uWscHAYA7xrGS3wZvmhrrdr1BgQYNUIzCn6kBqjCQmMFzxnR5sETdVDeTKTkQZ
+E5pNgxFlo0ZtCykNf3leCmIlOXFeBgtP/P6v1+9cG68Hch9mcr4dpiDhPuE/ZmXO
+x9As2fEHakx3dsW009RkjUXnmGJZ05FpQohC42JCJx1H8LpgtaQrmTH+CEzcOyo3
+jhj8ig0wggZxMIIEWaADAgECAgphCYEqAAAAAAACMA0GCSqGSIb3DQEBCwUAMIGI
+MQswCQYDVQQGEwJVUzETMBEGA1UECBMKV2FzaGluZ3RvbjEQMA4GA1UEBxMHUmVk
+bW9uZDEeMBwGA1UEChMVTWljcm9zb2Z0IENvcnBvcmF0aW9uMTIwMAYDVQQDEylN
+aWNyb3NvZnQgUm9vdCBDZXJ0aWZpY2F0ZSBBdXRob3JpdHkgMjAxMDAeFw0xMDA3
+MDEyMTM2NTVaFw0yNTA3MDEyMTQ2NTVaMHwxCzAJBgNVBAYTAlVTMRMwEQYDVQQI
+EwpXYXNoaW5ndG9uMRAwDgYDVQQHEwdSZWRtb25kMR4wHAYDVQQKExVNaWNyb3Nv
+ZnQgQ29ycG9yYXRpb24xJjAkBgNVBAMTHU1pY3Jvc29mdCBUaW1lLVN0YW1wIFBD
+QSAyMDEwMIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAqR0NvHcRijog
+7PwTl/X6f2mUa3RUENWlCgCChfvtfGhLLF/Fw+Vhwna3PmYrW/AVUycEMR9BGxqV
+Hc4JE458YTBZsTBED/FgiIRUQwzXTbg4CLNC3ZOs1nMwVyaCo0UN0Or1R4HNvyRg
+MlhgRvJYR4YyhB50YWeRX4FUsc+TTJLBxKZd0WETbijGGvmGgLvfYfxGwScdJGcS
+chohiq9LZIlQYrFd/XcfPfBXday9ikJNQFHRD5wGPmd/9WbAA5ZEfu/QS/1u5ZrK
+sajyeioKMfDaTgaRtogINeh4HLDpmc085y9Euqf03GS9pAHBIAmTeM38vMDJRF1e
+FpwBBU8iTQIDAQABo4IB5jCCAeIwEAYJKwYBBAGCNxUBBAMCAQAwHQYDVR0OBBYE
+FNVjOlyKMZDzQ3t8RhvFM2hahW1VMBkGCSsGAQQBgjcUAgQMHgoAUwB1AGIAQwBB
+MAsGA1UdDwQEAwIBhjAPBgNVHRMBAf8EBTADAQH/MB8GA1UdIwQYMBaAFNX2VsuP
+6KJcYmjRPZSQW9fOmhjEMFYGA1UdHwRPME0wS6BJoEeGRWh0dHA6Ly9jcmwubWlj
+cm9zb2Z0LmNvbS9wa2kvY3JsL3Byb2R1Y3RzL01pY1Jvb0NlckF1dF8yMDEwLTA2
+LTIzLmNybDBaBggrBgEFBQcBAQROMEwwSgYIKwYBBQUHMAKGPmh0dHA6Ly93d3cu
+bWljcm9zb2Z0LmNvbS9wa2kvY2VydHMvTWljUm9vQ2VyQXV0XzIwMTAtMDYtMjMu
+Y3J0MIGgBgNVHSABAf8EgZUwgZIwgY8GCSsGAQQBgjcuAzCBgTA9BggrBgEFBQcC
+ARYxaHR0cDovL3d3dy5taWNyb3NvZnQuY29tL1BLSS9kb2NzL0NQUy9kZWZhdWx0
+Lmh0bTBABggrBgEFBQcCAjA0HjIgHQBMAGUAZwBhAGwAXwBQAG8AbABpAGMAeQBf
+AFMAdABhAHQAZQBtAGUAbgB0AC4gHTANBgkqhkiG9w0BAQsFAAOCAgEAB+aIUQ3i
+xuCYP4FxAz2do6Ehb7Prpsz1Mb7PBeKp/vpXbRkws8LFZslq3/Xn8Hi9x6ieJeP5
+vO1rVFcIK1GCRBL7uVOMzPRgEop2zEBAQZvcXBf/XPleFzWYJFZLdO9CEMivv3/G
+f/I3fVo/HPKZeUqRUgCvOA8X9S95gWXZqbVr5MfO9sp6AG9LMEQkIjzP7QOllo9Z
+Kby2/QThcJ8ySif9Va8v/rbljjO7Yl+a21dA6fHOmWaQjP9qYn/dxUoLkSbiOewZ
+SnFjnXshbcOco6I8+n99lmqQeKZt0uGc+R38ONiU9MalCpaGpL2eGq4EQoO4tYCb
+IjggtSXlZOz39L9+Y1klD3ouOVd2onGqBooPiRa6YacRy5rYDkeagMXQzafQ732D
+8OE7cQnfXXSYIghh2rBQHm+98eEA3+cxB6STOvdlR3jo+KhIq/fecn5ha293qYHL
+pwmsObvsxsvYgrRyzR30uIUBHoD7G4kqVDmyW9rIDVWZeodzOwjmmC3qjeAzLhIp
+9cAvVCch98isTtoouLGp25ayp0Kiyc8ZQU3ghvkqmqMRZjDTu3QyS99je/WZii8b
+xyGvWbWu3EQ8l1Bx16HSxVXjad5XwdHeMMD9zOZN+w2/XU/pnR4ZOC+8z1gFLu8N
+oFA12u8JJxzVs341Hgi62jbb01+P3nSISRKhggLOMIICNwIBATCB+KGB0KSBzTCB
+yjELMAkGA1UEBhMCVVMxCzAJBgNVBAgTAldBMRAwDgYDVQQHEwdSZWRtb25kMR4w
+HAYDVQQKExVNaWNyb3NvZnQgQ29ycG9yYXRpb24xLTArBgNVBAsTJE1pY3Jvc29m
+dCBJcmVsYW5kIE9wZXJhdGlvbnMgTGltaXRlZDEmMCQGA1UECxMdVGhhbGVzIFRT
+UyBFU046M0JENC00QjgwLTY5QzMxJTAjBgNVBAMTHE1pY3Jvc29mdCBUaW1lLVN0
+YW1wIFNlcnZpY2WiIwoBATAHBgUrDgMCGgMVAPH9+R0xalPc8IoSPZLZrD4KcDBS
+oIGDMIGApH4wfDELMAkGA1UEBhMCVVMxEzARBgNVBAgTCldhc2hpbmd0b24xEDAO
+BgNVBAcTB1JlZG1vbmQxHjAcBgNVBAoTFU1pY3Jvc29mdCBDb3Jwb3JhdGlvbjEm
+MCQGA1UEAxMdTWljcm9zb2Z0IFRpbWUtU3RhbXAgUENBIDIwMTAwDQYJKoZIhvcN
+AQEFBQACBQDi6P0yMCIYDzIwMjAwODIwMjE0NjI2WhgPMjAyMDA4MjEyMTQ2MjZa
+MHcwPQYKKwYBBAGEWQoEATEvMC0wCgIFAOLo/TICAQAwCgIBAAICH6QCAf8wBwIB
+AAICEZkwCgIFAOLqTrICAQAwNgYKKwYBBAGEWQoEAjEoMCYwDAYKKwYBBAGEWQoD
+AqAKMAgCAQACAwehIKEKMAgCAQACAwGGoDANBgkqhkiG9w0BAQUFAAOBgQATNc2k
+wKnoYP7OKS/C/MSrkDM1WqIUZyJiWpC8oe3eOGKw6ftPvbtF32n23tNMAEnVeJ1J
+utkbYKDADIBtWbFNa3Z0qXt6pCD4eQpCsRVY3rCW2sxPZjW0eTKRNgDtzXgFlIP0
+X1Dd0Sn6ZXNfi4fzE5dBK7Wmc+TLeHFLOwQmQjGCAw0wggMJAgEBMIGTMHwxCzAJ
+BgNVBAYTAlVTMRMwEQYDVQQIEwpXYXNoaW5ndG9uMRAwDgYDVQQHEwdSZWRtb25k
+MR4wHAYDVQQKExVNaWNyb3NvZnQgQ29ycG9yYXRpb24xJjAkBgNVBAMTHU1pY3Jv
+c29mdCBUaW1lLVN0YW1wIFBDQSAyMDEwAhMzAAABC+T5vo9vTB3QAAAAAAELMA0G
+CWCGSAFlAwQCAQUAoIIBSjAaBgkqhkiG9w0BCQMxDQYLKoZIhvcNAQkQAQQwLwYJ
+KoZIhvcNAQkEMSIEINF8R2MTfYr3yL4pEheTkolSEGMAqm+3rwYroFY1ffELMIH6
+BgsqhkiG9w0BCRACLzGB6jCB5zCB5DCBvQQgNI/QziBTPjokl/FwJFwF4r0UdCzx
+wOnFVPwEwBNcc4gwgZgwgYCkfjB8MQswCQYDVQQGEwJVUzETMBEGA1UECBMKV2Fz
+aGluZ3RvbjEQMA4GA1UEBxMHUmVkbW9uZDEeMBwGA1UEChMVTWljcm9zb2Z0IENv
+cnBvcmF0aW9uMSYwJAYDVQQDEx1NaWNyb3NvZnQgVGltZS1TdGFtcCBQQ0EgMjAx
+MAITMwAAAQvk+b6Pb0wd0AAAAAABCzAiBCDvIrZTcNuF6eAq06DnK4IXw4ZIiQZ9
+WYEwD4435NbmxzANBgkqhkiG9w0BAQsFAASCAQAG9lu27//8XH+MsXn1AvVQ1+B3
+vDgHywRZlj79d9rqdFHLF6chGIHgpnvrU67VGhkmB3TrOJiPUzkGo30XWspBi572
+YXSFEgxiKn6oBTQq1SJTuaWo/3mst/gVRNZGrLFnkO+n+ju+9EiGcjaQb9G8fvAV
+pPM4TBRyonD6VNXpxzeRr1N1qd8eUnI43iFUvnlo/o9uS3XQmhAT3q/MWwCoJJXF
+/qil7H9RoZ4OHMkpINcMoN3y4WmZswXYf0VwKuIXab3VZt7cbO0vFiGWiuXOgEBx
+6ijbTMsWgWTCcsHnulkkDQ/yRdhmLIcRqvfmmGRNHKJryk2mnOFke0rADu97
+-----END PKCS7-----
+`
@@ -0,0 +1,182 @@
+package pkcs7
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"golang.org/x/xerrors"
+)
+
+var oidAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+
+// authEnvelopedData is the AuthEnvelopedData of RFC 5083. The authenticated
+// attributes are kept as encoded, since their DER is the additional data the
+// content is authenticated with.
+type authEnvelopedData struct {
+	Version                  int
+	OriginatorInfo           originatorInfo  `asn1:"optional,tag:0"`
+	RecipientInfos           []asn1.RawValue `asn1:"set"`
+	AuthEncryptedContentInfo encryptedContentInfo
+	AuthAttrs                asn1.RawValue `asn1:"optional,tag:1"`
+	MAC                      []byte
+	UnauthAttrs              []attribute `asn1:"optional,set,tag:2"`
+}
+
+// parseAuthEnvelopedData decodes an AuthEnvelopedData, exposing the
+// certificates and CRLs of its originatorInfo as Certificates and CRLs
+func parseAuthEnvelopedData(data []byte) (*PKCS7, error) {
+	var ed authEnvelopedData
+	if _, err := asn1.Unmarshal(data, &ed); err != nil {
+		return nil, err
+	}
+	certs, err := ed.OriginatorInfo.Certificates.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS7{
+		Certificates: certs,
+		CRLs:         ed.OriginatorInfo.CRLs,
+		version:      ed.Version,
+		raw:          ed,
+	}, nil
+}
+
+// additionalData returns the authenticated attributes encoded with the SET OF
+// tag instead of [1], which RFC 5083 makes the additional data of the cipher
+func (ed authEnvelopedData) additionalData() []byte {
+	if len(ed.AuthAttrs.FullBytes) == 0 {
+		return nil
+	}
+	aad := append([]byte(nil), ed.AuthAttrs.FullBytes...)
+	aad[0] = 0x31
+	return aad
+}
+
+// decrypt authenticates the attributes and decrypts the content with key
+func (ed authEnvelopedData) decrypt(key []byte) ([]byte, error) {
+	eci := ed.AuthEncryptedContentInfo
+	if !eci.ContentEncryptionAlgorithm.Algorithm.Equal(oidEncryptionAlgorithmAES128GCM) {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return eci.openAESGCM(block, append(eci.ciphertext(), ed.MAC...), ed.additionalData())
+}
+
+// AuthenticatedAttributes returns the authenticated attributes of a parsed
+// AuthEnvelopedData. Decrypt authenticates them along with the content, so
+// they can be trusted only once it succeeded.
+func (p7 *PKCS7) AuthenticatedAttributes() ([]RawAttribute, error) {
+	ed, ok := p7.raw.(authEnvelopedData)
+	if !ok {
+		return nil, ErrNotEncryptedContent
+	}
+	if len(ed.AuthAttrs.Bytes) == 0 {
+		return nil, nil
+	}
+	var attrs []attribute
+	if _, err := asn1.UnmarshalWithParams(ed.AuthAttrs.FullBytes, &attrs, "set,tag:1"); err != nil {
+		return nil, xerrors.Errorf("pkcs7: unmarshaling authenticated attributes: %w", err)
+	}
+	return exportAttributes(attrs)
+}
+
+// authAttributes encodes the authenticated attributes of config together with
+// the content-type attribute. It returns them tagged [1] for authAttrs and
+// tagged as a SET OF for the additional data, or nothing without attributes.
+func authAttributes(contentType asn1.ObjectIdentifier, config EnvelopeConfig) (tagged, additionalData []byte, err error) {
+	if len(config.AuthenticatedAttributes) == 0 {
+		return nil, nil, nil
+	}
+	var attrs attributes
+	attrs.Add(oidAttributeContentType, contentType)
+	for _, attr := range config.AuthenticatedAttributes {
+		attrs.Add(attr.Type, attr.Value)
+	}
+	sorted, err := attrs.ForMarshaling()
+	if err != nil {
+		return nil, nil, err
+	}
+	if additionalData, err = asn1.MarshalWithParams(sorted, "set"); err != nil {
+		return nil, nil, xerrors.Errorf("pkcs7: marshaling authenticated attributes: %w", err)
+	}
+	tagged = append([]byte(nil), additionalData...)
+	tagged[0] = 0xa1
+	return tagged, additionalData, nil
+}
+
+// newAESGCM creates a random AES-128 key and nonce and the GCM cipher for
+// them
+func newAESGCM() (key, nonce []byte, gcm cipher.AEAD, err error) {
+	key = make([]byte, 16)
+	if _, err = rand.Read(key); err != nil {
+		return nil, nil, nil, err
+	}
+	nonce = make([]byte, nonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if gcm, err = cipher.NewGCM(block); err != nil {
+		return nil, nil, nil, err
+	}
+	return key, nonce, gcm, nil
+}
+
+// newAuthEnvelope encrypts the content encryption key for every recipient and
+// returns the AuthEnvelopedData around eci with the authenticated attributes
+// authAttrs tagged [1]. The MAC is left to the caller.
+func newAuthEnvelope(eci encryptedContentInfo, key []byte, recipients []*x509.Certificate, authAttrs []byte, config EnvelopeConfig) (authEnvelopedData, error) {
+	envelope, err := newEnvelope(eci, key, recipients, config)
+	if err != nil {
+		return authEnvelopedData{}, err
+	}
+	// unlike EnvelopedData the version is always 0
+	return authEnvelopedData{
+		OriginatorInfo:           envelope.OriginatorInfo,
+		RecipientInfos:           envelope.RecipientInfos,
+		AuthEncryptedContentInfo: eci,
+		AuthAttrs:                asn1.RawValue{FullBytes: authAttrs},
+		UnauthAttrs:              envelope.UnprotectedAttrs,
+	}, nil
+}
+
+// encryptAuthEnveloped encrypts content with AES-128-GCM into an
+// AuthEnvelopedData carrying the authenticated attributes of config
+func encryptAuthEnveloped(content []byte, contentType asn1.ObjectIdentifier, recipients []*x509.Certificate, config EnvelopeConfig) ([]byte, error) {
+	if config.IV != nil {
+		return nil, xerrors.New("pkcs7: caller supplied IV is not supported for AES-GCM, reusing a nonce breaks it")
+	}
+	key, nonce, gcm, err := newAESGCM()
+	if err != nil {
+		return nil, err
+	}
+	alg, err := aesGCMAlgorithm(nonce, gcm.Overhead())
+	if err != nil {
+		return nil, err
+	}
+	authAttrs, additionalData, err := authAttributes(contentType, config)
+	if err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nil, nonce, content, additionalData)
+	eci := encryptedContentInfo{
+		ContentType:                contentType,
+		ContentEncryptionAlgorithm: alg,
+		EncryptedContent:           marshalEncryptedContent(sealed[:len(content)]),
+	}
+	envelope, err := newAuthEnvelope(eci, key, recipients, authAttrs, config)
+	if err != nil {
+		return nil, err
+	}
+	envelope.MAC = sealed[len(content):]
+	return marshalContentInfo(oidAuthEnvelopedData, envelope)
+}
@@ -2,8 +2,13 @@ package pkcs7
 
 import (
 	"bytes"
+	"encoding/asn1"
 	"errors"
+	"fmt"
 	"io"
+	"sort"
+
+	"golang.org/x/xerrors"
 )
 
 type asn1Object interface {
@@ -15,25 +20,30 @@ type asn1Object interface {
 type asn1Structured struct {
 	tagBytes []byte
 	content  []asn1Object
+	bodyLen  int
 }
 
-func (s asn1Structured) BodyLen() (res int) {
-	for _, obj := range s.content {
-		res += obj.FullLen()
+func newStructured(tagBytes []byte, content []asn1Object) asn1Structured {
+	s := asn1Structured{tagBytes: tagBytes, content: content}
+	for _, obj := range content {
+		s.bodyLen += obj.FullLen()
 	}
-	return
+	return s
+}
+
+func (s asn1Structured) BodyLen() int {
+	return s.bodyLen
 }
 
-func (s asn1Structured) FullLen() (res int) {
-	length := s.BodyLen()
-	return s.BodyLen() + len(s.tagBytes) + len(encodeLength(length))
+func (s asn1Structured) FullLen() int {
+	return s.bodyLen + len(s.tagBytes) + encodedLengthLen(s.bodyLen)
 }
 
 func (s asn1Structured) EncodeTo(out io.Writer) (err error) {
 	if _, err = out.Write(s.tagBytes); err != nil {
 		return
 	}
-	if _, err = out.Write(encodeLength(s.BodyLen())); err != nil {
+	if _, err = out.Write(encodeLength(s.bodyLen)); err != nil {
 		return
 	}
 	for _, obj := range s.content {
@@ -68,21 +78,27 @@ func (p asn1Primitive) BodyLen() int {
 
 func (p asn1Primitive) FullLen() int {
 	length := p.BodyLen()
-	return p.BodyLen() + len(p.tagBytes) + len(encodeLength(length))
+	return length + len(p.tagBytes) + encodedLengthLen(length)
 }
 
 func ber2der(ber []byte) ([]byte, error) {
+	return ParseOptions{}.ber2der(ber)
+}
+
+// ber2der transcodes ber to DER, accepting the encodings opts allows
+func (opts ParseOptions) ber2der(ber []byte) ([]byte, error) {
 	if len(ber) == 0 {
 		return nil, errors.New("ber2der: input ber is empty")
 	}
 	//fmt.Printf("--> ber2der: Transcoding %d bytes\n", len(ber))
-	out := new(bytes.Buffer)
-
-	obj, _, err := readObject(ber, 0)
+	obj, _, err := opts.readObject(ber, 0)
 	if err != nil {
 		return nil, err
 	}
-	obj.EncodeTo(out)
+	out := bytes.NewBuffer(make([]byte, 0, obj.FullLen()))
+	if err = encodeObject(obj, out); err != nil {
+		return nil, err
+	}
 
 	// if offset < len(ber) {
 	//	return nil, fmt.Errorf("ber2der: Content longer than expected. Got %d, expected %d", offset, len(ber))
@@ -91,6 +107,198 @@ func ber2der(ber []byte) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+func encodeObject(obj asn1Object, out io.Writer) error {
+	if err := obj.EncodeTo(out); err != nil {
+		return xerrors.Errorf("ber2der: encoding object: %w", err)
+	}
+	return nil
+}
+
+// isDER reports whether ber holds exactly one object in which every length is
+// definite and minimally encoded and no OCTET STRING is fragmented, so that
+// transcoding it with ber2der would be a no-op.
+func isDER(ber []byte) bool {
+	end, ok := scanDER(ber, 0)
+	return ok && end == len(ber)
+}
+
+func scanDER(ber []byte, offset int) (int, bool) {
+	if offset >= len(ber) {
+		return 0, false
+	}
+	b := ber[offset]
+	offset++
+	if b == 0x24 { // constructed OCTET STRING
+		return 0, false
+	}
+	if b&0x1F == 0x1F {
+		for offset < len(ber) && ber[offset] >= 0x80 {
+			offset++
+		}
+		offset++
+	}
+	if offset >= len(ber) {
+		return 0, false
+	}
+	l := ber[offset]
+	offset++
+	var length int
+	switch {
+	case l == 0x80:
+		return 0, false
+	case l > 0x80:
+		numberOfBytes := int(l & 0x7F)
+		if numberOfBytes > 4 || offset+numberOfBytes > len(ber) || ber[offset] == 0 {
+			return 0, false
+		}
+		if numberOfBytes == 4 && ber[offset] > 0x7F {
+			return 0, false
+		}
+		for i := 0; i < numberOfBytes; i++ {
+			var ok bool
+			if length, ok = accumulateLength(length, ber[offset]); !ok {
+				return 0, false
+			}
+			offset++
+		}
+		if length < 0x80 {
+			return 0, false
+		}
+	default:
+		length = int(l)
+	}
+	if length > len(ber)-offset {
+		return 0, false
+	}
+	end := offset + length
+	if b&0x20 != 0 {
+		for offset < end {
+			var ok bool
+			if offset, ok = scanDER(ber[:end], offset); !ok {
+				return 0, false
+			}
+		}
+	}
+	return end, true
+}
+
+// hasIndefiniteLength reports whether any object in ber uses the indefinite
+// length form. Children of constructed objects follow their header directly,
+// so a flat walk over the headers visits every object.
+func hasIndefiniteLength(ber []byte) bool {
+	for offset := 0; offset < len(ber); {
+		b := ber[offset]
+		offset++
+		if b&0x1F == 0x1F {
+			for offset < len(ber) && ber[offset] >= 0x80 {
+				offset++
+			}
+			offset++
+		}
+		if offset >= len(ber) {
+			return false
+		}
+		l := ber[offset]
+		offset++
+		length := int(l)
+		switch {
+		case l == 0x80:
+			return true
+		case l > 0x80:
+			numberOfBytes := int(l & 0x7F)
+			if numberOfBytes > 4 || offset+numberOfBytes > len(ber) {
+				return false
+			}
+			length = 0
+			for i := 0; i < numberOfBytes; i++ {
+				var ok bool
+				if length, ok = accumulateLength(length, ber[offset]); !ok {
+					return false
+				}
+				offset++
+			}
+		}
+		if b&0x20 == 0 {
+			if length > len(ber)-offset {
+				return false
+			}
+			offset += length
+		}
+	}
+	return false
+}
+
+// accumulateLength appends the next big-endian octet b of a length to length,
+// reporting false instead of overflowing int
+func accumulateLength(length int, b byte) (int, bool) {
+	if length > (maxInt-int(b))/256 {
+		return 0, false
+	}
+	return length*256 + int(b), true
+}
+
+// canonicalDER re-encodes der, a definite length encoding such as the output
+// of ber2der, as DER proper: constructed OCTET STRINGs are joined into
+// primitive ones and the elements of every SET are sorted by their encoding.
+// Implicitly tagged strings and sets cannot be told apart from other types and
+// are left as they are.
+func canonicalDER(der []byte) ([]byte, error) {
+	elements, err := canonicalElements(der)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(elements, nil), nil
+}
+
+func canonicalElements(der []byte) ([][]byte, error) {
+	var elements [][]byte
+	for len(der) > 0 {
+		var v asn1.RawValue
+		rest, err := asn1.Unmarshal(der, &v)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := canonicalValue(v)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, encoded)
+		der = rest
+	}
+	return elements, nil
+}
+
+func canonicalValue(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		return v.FullBytes, nil
+	}
+	children, err := canonicalElements(v.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	universal := v.Class == asn1.ClassUniversal
+	switch {
+	case universal && v.Tag == asn1.TagOctetString:
+		var content []byte
+		for _, child := range children {
+			var fragment asn1.RawValue
+			if _, err = asn1.Unmarshal(child, &fragment); err != nil {
+				return nil, err
+			}
+			if fragment.Class != asn1.ClassUniversal || fragment.Tag != asn1.TagOctetString {
+				return nil, errors.New("ber2der: constructed OCTET STRING holds another type")
+			}
+			content = append(content, fragment.Bytes...)
+		}
+		return asn1.Marshal(asn1.RawValue{Tag: asn1.TagOctetString, Bytes: content})
+	case universal && v.Tag == asn1.TagSet:
+		sort.Slice(children, func(i, j int) bool {
+			return bytes.Compare(children[i], children[j]) < 0
+		})
+	}
+	return asn1.Marshal(asn1.RawValue{Class: v.Class, Tag: v.Tag, IsCompound: true, Bytes: bytes.Join(children, nil)})
+}
+
 // computes the byte length of an encoded length value
 func lengthLength(i int) (numBytes int) {
 	numBytes = 1
@@ -101,6 +309,24 @@ func lengthLength(i int) (numBytes int) {
 	return
 }
 
+// computes the byte length of a DER encoded length value including the
+// initial length octet
+func encodedLengthLen(length int) int {
+	if length < 128 {
+		return 1
+	}
+	return 1 + lengthLength(length)
+}
+
+// shortLengths holds the encodings of lengths below 128 so that encoding them
+// does not allocate
+var shortLengths = func() (res [128]byte) {
+	for i := range res {
+		res[i] = byte(i)
+	}
+	return
+}()
+
 // encodes the length in DER format
 // If the length fits in 7 bits, the value is encoded directly.
 //
@@ -117,8 +343,7 @@ func lengthLength(i int) (numBytes int) {
 //
 func encodeLength(length int) (res []byte) {
 	if length < 128 {
-		res = []byte{byte(length)}
-		return
+		return shortLengths[length : length+1 : length+1]
 	}
 	n := lengthLength(length)
 	res = make([]byte, n+1)
@@ -131,6 +356,18 @@ func encodeLength(length int) (res []byte) {
 }
 
 func readObject(ber []byte, offset int) (asn1Object, int, error) {
+	return ParseOptions{}.readObject(ber, offset)
+}
+
+func (opts ParseOptions) readObject(ber []byte, offset int) (asn1Object, int, error) {
+	var stack []asn1Object
+	return opts.readObjectStack(ber, offset, &stack)
+}
+
+// readObjectStack reads the object at offset collecting the children of
+// constructed objects on the shared stack, so that each constructed object
+// allocates its content slice only once
+func (opts ParseOptions) readObjectStack(ber []byte, offset int, stack *[]asn1Object) (asn1Object, int, error) {
 	//fmt.Printf("\n====> Starting readObject at offset: %d\n\n", offset)
 	tagStart := offset
 	b := ber[offset]
@@ -162,38 +399,57 @@ func readObject(ber []byte, offset int) (asn1Object, int, error) {
 	indefinite := false
 	if l > 0x80 {
 		numberOfBytes := (int)(l & 0x7F)
+		lenient := opts.LenientLengths && !opts.StrictDER
+		if lenient {
+			// leading zero octets do not change the value and DER drops them
+			for numberOfBytes > 0 && offset < len(ber) && ber[offset] == 0 {
+				offset++
+				numberOfBytes--
+			}
+		}
 		if numberOfBytes > 4 { // int is only guaranteed to be 32bit
 			return nil, 0, errors.New("ber2der: BER tag length too long")
 		}
+		if numberOfBytes > len(ber)-offset {
+			return nil, 0, errors.New("ber2der: BER tag length is more than available data")
+		}
 		if numberOfBytes == 4 && (int)(ber[offset]) > 0x7F {
 			return nil, 0, errors.New("ber2der: BER tag length is negative")
 		}
-		if 0x0 == (int)(ber[offset]) {
+		if !lenient && 0x0 == (int)(ber[offset]) {
 			return nil, 0, errors.New("ber2der: BER tag length has leading zero")
 		}
 		//fmt.Printf("--> (compute length) indicator byte: %x\n", l)
 		//fmt.Printf("--> (compute length) length bytes: % X\n", ber[offset:offset+numberOfBytes])
 		for i := 0; i < numberOfBytes; i++ {
-			length = length*256 + (int)(ber[offset])
+			var ok bool
+			if length, ok = accumulateLength(length, ber[offset]); !ok {
+				return nil, 0, errors.New("ber2der: BER tag length overflows int")
+			}
 			offset++
 		}
 	} else if l == 0x80 {
+		if opts.StrictDER {
+			return nil, 0, fmt.Errorf("ber2der: Indefinite length is not allowed in strict DER mode (offset %d)", tagStart)
+		}
 		indefinite = true
 	} else {
 		length = (int)(l)
 	}
 
 	//fmt.Printf("--> length        : %d\n", length)
-	contentEnd := offset + length
-	if contentEnd > len(ber) {
+	// compare with the remaining data before adding, offset + length may
+	// overflow int
+	if length > len(ber)-offset {
 		return nil, 0, errors.New("ber2der: BER tag length is more than available data")
 	}
+	contentEnd := offset + length
 	//fmt.Printf("--> content start : %d\n", offset)
 	//fmt.Printf("--> content end   : %d\n", contentEnd)
 	//fmt.Printf("--> content       : % X\n", ber[offset:contentEnd])
 	var obj asn1Object
 	if indefinite && kind == 0 {
-		return nil, 0, errors.New("ber2der: Indefinite form tag must have constructed encoding")
+		return nil, 0, fmt.Errorf("ber2der: Indefinite form tag must have constructed encoding (offset %d)", tagStart)
 	}
 	if kind == 0 {
 		obj = asn1Primitive{
@@ -201,16 +457,10 @@ func readObject(ber []byte, offset int) (asn1Object, int, error) {
 			content:  ber[offset:contentEnd],
 		}
 	} else {
-		var subObjects []asn1Object
+		base := len(*stack)
 		for (offset < contentEnd) || indefinite {
-			var subObj asn1Object
-			var err error
-			subObj, offset, err = readObject(ber, offset)
-			if err != nil {
-				return nil, 0, err
-			}
-			subObjects = append(subObjects, subObj)
-
+			// look for the terminator before every child, so that an empty
+			// indefinite-length object does not take its parent's one
 			if indefinite {
 				terminated, err := isIndefiniteTermination(ber, offset)
 				if err != nil {
@@ -221,11 +471,18 @@ func readObject(ber []byte, offset int) (asn1Object, int, error) {
 					break
 				}
 			}
+			var subObj asn1Object
+			var err error
+			subObj, offset, err = opts.readObjectStack(ber, offset, stack)
+			if err != nil {
+				return nil, 0, err
+			}
+			*stack = append(*stack, subObj)
 		}
-		obj = asn1Structured{
-			tagBytes: ber[tagStart:tagEnd],
-			content:  subObjects,
-		}
+		subObjects := make([]asn1Object, len(*stack)-base)
+		copy(subObjects, (*stack)[base:])
+		*stack = (*stack)[:base]
+		obj = newStructured(ber[tagStart:tagEnd], subObjects)
 	}
 
 	// Apply indefinite form length with 0x0000 terminator.
@@ -241,5 +498,5 @@ func isIndefiniteTermination(ber []byte, offset int) (bool, error) {
 		return false, errors.New("ber2der: Invalid BER format")
 	}
 
-	return bytes.Index(ber[offset:], []byte{0x0, 0x0}) == 0, nil
+	return ber[offset] == 0 && ber[offset+1] == 0, nil
 }
@@ -2,9 +2,14 @@ package pkcs7
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/asn1"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
+
+	"golang.org/x/xerrors"
 )
 
 func TestBer2Der(t *testing.T) {
@@ -37,6 +42,48 @@ func TestBer2Der(t *testing.T) {
 	}
 }
 
+func TestBer2Der_StrictDER(t *testing.T) {
+	ber := []byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00}
+	if _, err := ber2der(ber); err != nil {
+		t.Fatalf("ber2der failed with error: %v", err)
+	}
+	strict := ParseOptions{StrictDER: true}
+	_, err := strict.ber2der(ber)
+	if err == nil || !strings.Contains(err.Error(), "not allowed in strict DER mode") {
+		t.Errorf("expected strict DER error, got %v", err)
+	}
+	if _, err = strict.ber2der([]byte{0x30, 0x03, 0x02, 0x01, 0x01}); err != nil {
+		t.Errorf("ber2der on DER bytes failed with error: %v", err)
+	}
+}
+
+func TestBer2Der_LenientLengths(t *testing.T) {
+	ber := []byte{0x30, 0x83, 0x00, 0x00, 0x04, 0x02, 0x81, 0x01, 0x01}
+	expected := []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	_, err := ber2der(ber)
+	if err == nil || !strings.Contains(err.Error(), "length has leading zero") {
+		t.Fatalf("expected leading zero error, got %v", err)
+	}
+	lenient := ParseOptions{LenientLengths: true}
+	der, err := lenient.ber2der(ber)
+	if err != nil {
+		t.Fatalf("ber2der failed with error: %v", err)
+	}
+	if !bytes.Equal(der, expected) {
+		t.Errorf("ber2der result did not match.\n\tExpected: % X\n\tActual: % X", expected, der)
+	}
+	// more than four length octets are fine while the excess ones are zero
+	if der, err = lenient.ber2der([]byte{0x30, 0x86, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Errorf("ber2der failed with error: %v", err)
+	} else if !bytes.Equal(der, []byte{0x30, 0x00}) {
+		t.Errorf("expected an empty sequence, got % X", der)
+	}
+	lenient.StrictDER = true
+	if _, err = lenient.ber2der(ber); err == nil {
+		t.Error("expected strict DER mode to reject leading zeros")
+	}
+}
+
 func TestBer2Der_Negatives(t *testing.T) {
 	fixtures := []struct {
 		Input         []byte
@@ -47,6 +94,9 @@ func TestBer2Der_Negatives(t *testing.T) {
 		{[]byte{0x30, 0x82, 0x0, 0x1}, "length has leading zero"},
 		{[]byte{0x30, 0x80, 0x1, 0x2, 0x1, 0x2}, "Invalid BER format"},
 		{[]byte{0x30, 0x03, 0x01, 0x02}, "length is more than available data"},
+		{[]byte{0x30, 0x84, 0x7f, 0xff}, "length is more than available data"},
+		{[]byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xfe, 0x01, 0x02}, "length is more than available data"},
+		{[]byte{0x30, 0x80, 0x04, 0x80, 0x01, 0x00, 0x00, 0x00, 0x00}, "must have constructed encoding (offset 2)"},
 	}
 
 	for _, fixture := range fixtures {
@@ -60,6 +110,25 @@ func TestBer2Der_Negatives(t *testing.T) {
 	}
 }
 
+func TestAccumulateLength(t *testing.T) {
+	length := 0
+	var ok bool
+	for i := 0; i < lengthLength(maxInt); i++ {
+		if length, ok = accumulateLength(length, byte(maxInt>>uint(8*(lengthLength(maxInt)-1-i)))); !ok {
+			t.Fatalf("unexpected overflow at byte %d", i)
+		}
+	}
+	if length != maxInt {
+		t.Fatalf("expected %d, got %d", maxInt, length)
+	}
+	if _, ok = accumulateLength(length, 0); ok {
+		t.Error("expected overflow past the largest int")
+	}
+	if _, ok = accumulateLength(maxInt/256+1, 0); ok {
+		t.Error("expected overflow shifting past the largest int")
+	}
+}
+
 func TestBer2Der_NestedMultipleIndefinite(t *testing.T) {
 	// indefinite length fixture
 	ber := []byte{0x30, 0x80, 0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00, 0x30, 0x80, 0x02, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00}
@@ -95,3 +164,186 @@ func TestBer2Der_NestedMultipleIndefinite(t *testing.T) {
 		t.Errorf("Resulting DER has trailing data: % X", rest)
 	}
 }
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestBer2Der_DeeplyNestedIndefinite(t *testing.T) {
+	// the layout of OpenSSL streaming output: ContentInfo, [0], SignedData,
+	// encapsulated ContentInfo, [0] and a fragmented OCTET STRING, all of
+	// indefinite length, followed by an empty indefinite-length SET
+	ber := []byte{
+		0x30, 0x80,
+		0x06, 0x01, 0x01,
+		0xa0, 0x80,
+		0x30, 0x80,
+		0x30, 0x80,
+		0x06, 0x01, 0x02,
+		0xa0, 0x80,
+		0x24, 0x80,
+		0x04, 0x02, 'a', 'b',
+		0x04, 0x01, 'c',
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x31, 0x80, 0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+	}
+	expected := []byte{
+		0x30, 0x19,
+		0x06, 0x01, 0x01,
+		0xa0, 0x14,
+		0x30, 0x12,
+		0x30, 0x0e,
+		0x06, 0x01, 0x02,
+		0xa0, 0x09,
+		0x24, 0x07,
+		0x04, 0x02, 'a', 'b',
+		0x04, 0x01, 'c',
+		0x31, 0x00,
+	}
+	der, err := ber2der(ber)
+	if err != nil {
+		t.Fatalf("ber2der failed with error: %v", err)
+	}
+	if !bytes.Equal(der, expected) {
+		t.Errorf("ber2der result did not match.\n\tExpected: % X\n\tActual: % X", expected, der)
+	}
+	if _, end, err := readObject(append(ber, 0x00, 0x00), 0); err != nil || end != len(ber) {
+		t.Errorf("expected the unbalanced terminator to be left over, got end %d of %d: %v", end, len(ber), err)
+	}
+}
+
+func TestBer2Der_EncodeError(t *testing.T) {
+	obj, _, err := readObject([]byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00}, 0)
+	if err != nil {
+		t.Fatalf("readObject failed with error: %v", err)
+	}
+	writeErr := errors.New("write failed")
+	err = encodeObject(obj, failingWriter{writeErr})
+	if err == nil {
+		t.Fatal("expected encoding error")
+	}
+	if unwrapped := xerrors.Unwrap(err); unwrapped != writeErr {
+		t.Errorf("unexpected unwrapped error: %v", unwrapped)
+	}
+	if !strings.Contains(err.Error(), "ber2der: encoding object") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestIsDER(t *testing.T) {
+	fixtures := []struct {
+		Input    []byte
+		Expected bool
+	}{
+		{[]byte{0x30, 0x03, 0x02, 0x01, 0x01}, true},
+		{[]byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00}, false},
+		{[]byte{0x30, 0x81, 0x03, 0x02, 0x01, 0x01}, false},
+		{[]byte{0x24, 0x03, 0x04, 0x01, 0x01}, false},
+		{[]byte{0x30, 0x03, 0x02, 0x01, 0x01, 0x00}, false},
+		{[]byte{0x30, 0x04, 0x02, 0x01, 0x01}, false},
+	}
+	for _, fixture := range fixtures {
+		if actual := isDER(fixture.Input); actual != fixture.Expected {
+			t.Errorf("isDER(% X): expected %v, got %v", fixture.Input, fixture.Expected, actual)
+		}
+	}
+}
+
+func BenchmarkBer2Der(b *testing.B) {
+	fixture := UnmarshalTestFixture(SignedTestFixture)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ber2der(fixture.Input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBer2DerLargeIndefinite transcodes an indefinite-length OCTET STRING
+// of many small fragments, as written by streaming encoders
+func BenchmarkBer2DerLargeIndefinite(b *testing.B) {
+	ber := []byte{0x24, 0x80}
+	for i := 0; i < 100000; i++ {
+		ber = append(ber, 0x04, 0x04, 'd', 'a', 't', 'a')
+	}
+	ber = append(ber, 0x00, 0x00)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(ber)))
+	for i := 0; i < b.N; i++ {
+		if _, err := ber2der(ber); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCanonicalDER(t *testing.T) {
+	// SET { INTEGER 5, INTEGER 3, OCTET STRING { "ab", { "c" } } } with the
+	// string fragmented and nested
+	input := []byte{
+		0x31, 0x13,
+		0x02, 0x01, 0x05,
+		0x02, 0x01, 0x03,
+		0x24, 0x0b, 0x04, 0x02, 'a', 'b', 0x24, 0x05, 0x04, 0x01, 'c', 0x04, 0x00,
+	}
+	expected := []byte{
+		0x31, 0x0b,
+		0x02, 0x01, 0x03,
+		0x02, 0x01, 0x05,
+		0x04, 0x03, 'a', 'b', 'c',
+	}
+	der, err := canonicalDER(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der, expected) {
+		t.Errorf("expected %x, got %x", expected, der)
+	}
+	if _, err = canonicalDER([]byte{0x24, 0x03, 0x02, 0x01, 0x00}); err == nil {
+		t.Error("expected error for an INTEGER inside an OCTET STRING")
+	}
+}
+
+func BenchmarkIsDER(b *testing.B) {
+	fixture := UnmarshalTestFixture(SignedTestFixture)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if !isDER(fixture.Input) {
+			b.Fatal("fixture is not DER")
+		}
+	}
+}
+
+func TestBer2Der_AppStoreReceipt(t *testing.T) {
+	fixture := UnmarshalTestFixture(AppStoreRecieptFixture)
+	der, err := ber2der(fixture.Input)
+	if err != nil {
+		t.Fatalf("ber2der failed with error: %v", err)
+	}
+	// digest of the transcoded receipt, guards against changes in output bytes
+	const expected = "e25c7284914adb4fe29436daa23f80546224e2360efaa5c3e7dd2288318a6508"
+	if actual := fmt.Sprintf("%x", sha256.Sum256(der)); actual != expected {
+		t.Errorf("ber2der output digest mismatch.\n\tExpected: %s\n\tActual: %s", expected, actual)
+	}
+	if !isDER(der) {
+		t.Error("ber2der result is not DER")
+	}
+}
+
+func BenchmarkBer2DerAppStore(b *testing.B) {
+	fixture := UnmarshalTestFixture(AppStoreRecieptFixture)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ber2der(fixture.Input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
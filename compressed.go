@@ -0,0 +1,95 @@
+package pkcs7
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	oidCompressedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 9}
+	oidZlibCompress   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 8}
+)
+
+type compressedData struct {
+	Version              int
+	CompressionAlgorithm pkix.AlgorithmIdentifier
+	ContentInfo          contentInfo
+}
+
+func parseCompressedData(data []byte) (*PKCS7, error) {
+	var cd compressedData
+	if _, err := asn1.Unmarshal(data, &cd); err != nil {
+		return nil, err
+	}
+	content, err := cd.decompress()
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS7{
+		Content: content,
+		version: cd.Version,
+		raw:     cd,
+	}, nil
+}
+
+// decompress returns the uncompressed encapsulated content
+func (cd compressedData) decompress() ([]byte, error) {
+	if !cd.CompressionAlgorithm.Algorithm.Equal(oidZlibCompress) {
+		return nil, xerrors.Errorf("pkcs7: compression algorithm %v: %w", cd.CompressionAlgorithm.Algorithm, ErrUnsupportedAlgorithm)
+	}
+	compressed, err := cd.ContentInfo.unwrap()
+	if err != nil {
+		return nil, err
+	}
+	return zlibDecompress(compressed)
+}
+
+// marshalCompressedData returns the DER encoded CompressedData holding the
+// zlib compressed content
+func marshalCompressedData(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		return nil, xerrors.Errorf("pkcs7: compressing content: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, xerrors.Errorf("pkcs7: compressing content: %w", err)
+	}
+	octets, err := asn1.Marshal(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(compressedData{
+		CompressionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidZlibCompress},
+		ContentInfo: contentInfo{
+			ContentType: oidData,
+			Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: octets, IsCompound: true},
+		},
+	})
+}
+
+// maxDecompressedSize caps the decompressed content, so that a small
+// compression bomb cannot exhaust the memory
+var maxDecompressedSize int64 = 256 << 20
+
+func zlibDecompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, xerrors.Errorf("pkcs7: decompressing content: %w", err)
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, xerrors.Errorf("pkcs7: decompressing content: %w", err)
+	}
+	if int64(len(content)) > maxDecompressedSize {
+		return nil, xerrors.Errorf("pkcs7: decompressed content over %d bytes: %w", maxDecompressedSize, ErrContentTooLarge)
+	}
+	return content, nil
+}
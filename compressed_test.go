@@ -0,0 +1,128 @@
+package pkcs7
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func marshalTestCompressedData(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	inner, err := asn1.Marshal(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	cd, err := asn1.Marshal(compressedData{
+		CompressionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidZlibCompress},
+		ContentInfo: contentInfo{
+			ContentType: oidData,
+			Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: inner, IsCompound: true},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidCompressedData,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: cd, IsCompound: true},
+	})
+}
+
+func TestParseCompressedData(t *testing.T) {
+	content := bytes.Repeat([]byte("compress me "), 100)
+	data, err := marshalTestCompressedData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, p7.Content) {
+		t.Error("decompressed content does not match")
+	}
+}
+
+func TestParseCompressedDataLimit(t *testing.T) {
+	defer func(max int64) { maxDecompressedSize = max }(maxDecompressedSize)
+	maxDecompressedSize = 1 << 10
+	content := make([]byte, maxDecompressedSize)
+	data, err := marshalTestCompressedData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Parse(data); err != nil {
+		t.Fatalf("content at the limit: %v", err)
+	}
+	if data, err = marshalTestCompressedData(append(content, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Parse(data); !xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected ErrContentTooLarge, got %v", err)
+	}
+}
+
+func TestSignCompressed(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("Hello World "), 1000)
+	toBeSigned, err := NewSignedData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.Compress(); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	if err = enc.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = enc.Compress(); err != nil {
+		t.Fatal(err)
+	}
+	if err = enc.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"Finish": signed, "SignFrom": buf.Bytes()} {
+		if len(data) >= len(content) {
+			t.Errorf("%s: expected compressed message, got %d bytes for %d bytes of content", name, len(data), len(content))
+		}
+		p7, err := Parse(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if ct := p7.raw.(signedData).ContentInfo.ContentType; !ct.Equal(oidCompressedData) {
+			t.Errorf("%s: expected compressed content type, got %v", name, ct)
+		}
+		if v := p7.Version(); v != 3 {
+			t.Errorf("%s: expected version 3, got %d", name, v)
+		}
+		if err = p7.Verify(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !bytes.Equal(p7.Content, content) {
+			t.Errorf("%s: decompressed content mismatch", name)
+		}
+	}
+}
@@ -1,31 +1,40 @@
 package pkcs7
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/hmac"
-	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"hash"
 	"io"
+	"io/ioutil"
 
 	"golang.org/x/xerrors"
 )
 
-// NewDecoder creates stream PKCS7 decoder
+// NewDecoder creates stream PKCS7 decoder. It does not decompress: the
+// content of a message signed with SignedData.Compress is written as its
+// CompressedData encoding, which Parse decompresses from memory.
 func NewDecoder(r io.Reader) *PKCS7 {
 	return &PKCS7{
 		r: newBerReader(r),
 	}
 }
 
+// ErrTooManyFragments is returned by the streaming decoder when the content is
+// split into more fragments than PKCS7.MaxContentFragments allows
+var ErrTooManyFragments = xerrors.New("pkcs7: content has too many fragments")
+
 func (p7 *PKCS7) buildHashes(dest io.Writer) continuation {
 	return func(class int, constructed bool, tag int, length int) (err error) {
-		r := io.LimitReader(p7.r, int64(length))
-		for _, h := range p7.hashes {
-			r = io.TeeReader(r, h)
+		p7.hasContent = true
+		if p7.fragments++; p7.MaxContentFragments > 0 && p7.fragments > p7.MaxContentFragments {
+			return ErrTooManyFragments
 		}
-		if _, err = io.Copy(dest, r); err != nil {
+		r := p7.contentReader(io.LimitReader(p7.r, int64(length)))
+		if _, err = io.Copy(io.MultiWriter(dest, p7.digest), r); err != nil {
 			return xerrors.Errorf("buildHashes: %w", err)
 		}
 		return nil
@@ -36,19 +45,50 @@ func (p7 *PKCS7) initHashes(class int, constructed bool, tag int, length int) (e
 	if err = p7.r._object(&p7.digestAlgorithmIdentifiers, "set")(class, constructed, tag, length); err != nil {
 		return xerrors.Errorf("initHashes: %w", err)
 	}
-	p7.hashes = make(map[crypto.Hash]hash.Hash)
-	for i, aid := range p7.digestAlgorithmIdentifiers {
+	if p7.hashes, err = newHashes(p7.digestAlgorithmIdentifiers); err != nil {
+		return xerrors.Errorf("initHashes: %w", err)
+	}
+	p7.digest = p7.digestWriter()
+	return
+}
+
+// digestWriter returns the writer feeding all content hashes, passed through
+// the Canonicalize hook if one is set
+func (p7 *PKCS7) digestWriter() io.Writer {
+	ws := make([]io.Writer, 0, len(p7.hashes))
+	for _, h := range p7.hashes {
+		ws = append(ws, h)
+	}
+	w := io.MultiWriter(ws...)
+	if p7.Canonicalize != nil {
+		w = p7.Canonicalize(w)
+	}
+	return w
+}
+
+// closeDigest flushes the canonicalizing writer after the content is read
+func (p7 *PKCS7) closeDigest() error {
+	if c, ok := p7.digest.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return xerrors.Errorf("closing canonicalizer: %w", err)
+		}
+	}
+	return nil
+}
+
+func newHashes(digestAlgorithms []pkix.AlgorithmIdentifier) (map[crypto.Hash]hash.Hash, error) {
+	res := make(map[crypto.Hash]hash.Hash)
+	for i, aid := range digestAlgorithms {
 		hash, err := getHashForOID(aid.Algorithm)
 		if err != nil {
-			return xerrors.Errorf("initHashes: digest %d: %w", i, err)
+			return nil, xerrors.Errorf("digest %d: %w", i, err)
 		}
-		p7.hashes[hash] = hash.New()
+		res[hash] = hash.New()
 	}
-	return
+	return res, nil
 }
 
 func (p7 *PKCS7) verifySignature(i int) error {
-	var signedData []byte
 	signer := p7.Signers[i]
 	hashType, err := getHashForOID(signer.DigestAlgorithm.Algorithm)
 	if err != nil {
@@ -58,69 +98,275 @@ func (p7 *PKCS7) verifySignature(i int) error {
 	if !ok {
 		return xerrors.Errorf("hash for signer %d not found", i)
 	}
-	computed := hash.Sum(nil)
-	if len(signer.AuthenticatedAttributes) > 0 {
-		// TODO(fullsailor): First check the content type match
-		var digest []byte
-		err := unmarshalAttribute(signer.AuthenticatedAttributes, oidAttributeMessageDigest, &digest)
-		if err != nil {
+	_, err = verifySignerInfo(signer, p7.Certificates, hash.Sum(nil))
+	return err
+}
+
+// portions Copyright 2009 The Go Authors.
+func isRSAPSS(algo x509.SignatureAlgorithm) bool {
+	switch algo {
+	case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrDetachedContent is returned by VerifyTo for a signed detached message,
+// which has no embedded content. Such messages are verified with VerifyExternalTo.
+var ErrDetachedContent = xerrors.New("pkcs7: message has no embedded content, use VerifyExternalTo")
+
+// VerifyTo parses underlying message stream and writes extracted content into writer
+func (p7 *PKCS7) VerifyTo(dest io.Writer) error {
+	return p7.verifyTo(dest, func() {})
+}
+
+// VerifyToSigners is like VerifyTo but also sends the certificate of every
+// signer found in the message on signers and closes the channel, so that
+// authorization can run in parallel with the rest of the work. The signer
+// infos follow the embedded content in the stream, so the certificates are
+// sent once the content is written into dest and before the signatures are
+// checked. Use VerifyExternalToSigners to learn them before the content is
+// read. The channel is closed on every return, and the caller has to receive
+// from it or make it buffered, otherwise verification blocks.
+func (p7 *PKCS7) VerifyToSigners(dest io.Writer, signers chan<- *x509.Certificate) error {
+	n := signerNotifier{ch: signers}
+	defer n.close()
+	return p7.verifyTo(dest, func() { n.send(p7) })
+}
+
+// defaultMaxHeldContent is the content VerifyTo holds back for OnSignerInfo
+// when PKCS7.MaxHeldContent is not set
+const defaultMaxHeldContent = 64 << 20
+
+func (p7 *PKCS7) verifyTo(dest io.Writer, signersKnown func()) error {
+	out := dest
+	var held *bytes.Buffer
+	if p7.OnSignerInfo != nil {
+		// nothing reaches dest before the signers are accepted
+		held = new(bytes.Buffer)
+		out = held
+		max := p7.MaxHeldContent
+		if max <= 0 {
+			max = defaultMaxHeldContent
+		}
+		if p7.limit <= 0 || p7.limit > max {
+			p7.limit = max
+		}
+	}
+	if err := p7.decode(out, true); err != nil {
+		return err
+	}
+	if err := p7.closeDigest(); err != nil {
+		return err
+	}
+	if err := p7.checkSigners(); err != nil {
+		return err
+	}
+	if held != nil {
+		if _, err := held.WriteTo(dest); err != nil {
 			return err
 		}
-		if !hmac.Equal(digest, computed) {
-			return &MessageDigestMismatchError{
-				ExpectedDigest: digest,
-				ActualDigest:   computed,
-			}
+	}
+	signersKnown()
+	return p7.verifySignatures()
+}
+
+// signerNotifier sends the signer certificates of a message on ch and closes
+// it exactly once
+type signerNotifier struct {
+	ch     chan<- *x509.Certificate
+	closed bool
+}
+
+// send passes the certificates of the signers that have one in the message
+// and closes the channel
+func (n *signerNotifier) send(p7 *PKCS7) {
+	for _, signer := range p7.Signers {
+		if cert := getCertFromCertsByIssuerAndSerial(p7.Certificates, signer.IssuerAndSerialNumber); cert != nil {
+			n.ch <- cert
 		}
-		// TODO(fullsailor): Optionally verify certificate chain
-		// TODO(fullsailor): Optionally verify signingTime against certificate NotAfter/NotBefore
-		if signedData, err = marshalAttributes(signer.AuthenticatedAttributes); err != nil {
+	}
+	n.close()
+}
+
+func (n *signerNotifier) close() {
+	if !n.closed {
+		close(n.ch)
+		n.closed = true
+	}
+}
+
+// checkSigners fails for a message without signers and passes every signer
+// to the OnSignerInfo callback
+func (p7 *PKCS7) checkSigners() error {
+	if len(p7.Signers) == 0 {
+		return xerrors.New("pkcs7: Message has no signers")
+	}
+	if p7.OnSignerInfo == nil {
+		return nil
+	}
+	for i, signer := range p7.Signers {
+		cert := getCertFromCertsByIssuerAndSerial(p7.Certificates, signer.IssuerAndSerialNumber)
+		if err := p7.OnSignerInfo(i, signer.IssuerAndSerialNumber.exported(), cert); err != nil {
 			return err
 		}
 	}
-	cert := getCertFromCertsByIssuerAndSerial(p7.Certificates, signer.IssuerAndSerialNumber)
-	if cert == nil {
-		return xerrors.New("pkcs7: No certificate for signer")
+	return nil
+}
+
+// ErrContentTooLarge is returned when content exceeds a size limit: by the
+// streaming decoder beyond MaxContentSize, MaxHeldContent or the limit of
+// VerifyToLimited, by EncryptFrom beyond EnvelopeEncoder.MaxBufferSize and by
+// decompression of CompressedData
+var ErrContentTooLarge = xerrors.New("pkcs7: content exceeds size limit")
+
+// VerifyToLimited is like VerifyTo but fails with ErrContentTooLarge as soon as
+// the embedded content grows beyond maxBytes, or beyond a smaller positive
+// MaxContentSize. A maxBytes that is not positive sets no limit of its own.
+func (p7 *PKCS7) VerifyToLimited(dest io.Writer, maxBytes int64) error {
+	p7.limit = maxBytes
+	return p7.VerifyTo(dest)
+}
+
+// ContentRead returns the number of content bytes the streaming decoder has
+// read so far, for VerifyExternalTo those of the external content
+func (p7 *PKCS7) ContentRead() int64 {
+	return p7.progress.n
+}
+
+// contentReader returns r wrapped by the reader counting the content bytes,
+// reporting the progress and enforcing the size limit of the message
+func (p7 *PKCS7) contentReader(r io.Reader) io.Reader {
+	p7.progress.r = r
+	p7.progress.limit = p7.MaxContentSize
+	if p7.limit > 0 && (p7.limit < p7.progress.limit || p7.progress.limit <= 0) {
+		p7.progress.limit = p7.limit
 	}
+	p7.progress.onProgress = p7.OnProgress
+	return &p7.progress
+}
 
-	algo, err := getSignAlgorithm(signer.DigestAlgorithm.Algorithm)
-	if err != nil {
+// contentReader counts the bytes read from r, calls onProgress with the count
+// and fails with ErrContentTooLarge once the count exceeds a positive limit,
+// all in one wrapper. The count persists across the fragments of the content
+// while r is replaced for each of them.
+type contentReader struct {
+	r          io.Reader
+	n          int64
+	limit      int64
+	onProgress func(read int64)
+}
+
+func (cr *contentReader) Read(p []byte) (int, error) {
+	if cr.limit > 0 && int64(len(p)) > cr.limit-cr.n {
+		// read one byte past the limit to tell whether there is more
+		p = p[:cr.limit-cr.n+1]
+	}
+	n, err := cr.r.Read(p)
+	if cr.limit > 0 && cr.n+int64(n) > cr.limit {
+		n = int(cr.limit - cr.n)
+		err = ErrContentTooLarge
+	}
+	cr.n += int64(n)
+	if n > 0 && cr.onProgress != nil {
+		cr.onProgress(cr.n)
+	}
+	return n, err
+}
+
+// ErrContentMismatch is returned when the content embedded in a message differs
+// from the externally supplied content
+var ErrContentMismatch = xerrors.New("pkcs7: embedded content does not match external content")
+
+// VerifyExternalTo parses underlying message stream and verifies signatures
+// against the content read from external, which is written into dest. The
+// content embedded in the message is not written anywhere, but if present it
+// must match the external content, otherwise ErrContentMismatch is returned.
+func (p7 *PKCS7) VerifyExternalTo(dest io.Writer, external io.Reader) (err error) {
+	return p7.verifyExternalTo(dest, external, func() {})
+}
+
+// VerifyExternalToSigners is like VerifyExternalTo but also sends the
+// certificate of every signer on signers and closes the channel. The message
+// is decoded before external is read, so the certificates arrive before any
+// content is written into dest. The channel is closed on every return, and
+// the caller has to receive from it or make it buffered, otherwise
+// verification blocks.
+func (p7 *PKCS7) VerifyExternalToSigners(dest io.Writer, external io.Reader, signers chan<- *x509.Certificate) error {
+	n := signerNotifier{ch: signers}
+	defer n.close()
+	return p7.verifyExternalTo(dest, external, func() { n.send(p7) })
+}
+
+func (p7 *PKCS7) verifyExternalTo(dest io.Writer, external io.Reader, signersKnown func()) (err error) {
+	if err = p7.decode(ioutil.Discard, false); err != nil {
+		return err
+	}
+	if err = p7.closeDigest(); err != nil {
+		return err
+	}
+	if err = p7.checkSigners(); err != nil {
+		return err
+	}
+	signersKnown()
+	embedded := p7.hashes
+	if p7.hashes, err = newHashes(p7.digestAlgorithmIdentifiers); err != nil {
 		return err
 	}
-	if len(signedData) != 0 {
-		return cert.CheckSignature(algo, signedData, signer.EncryptedDigest)
+	p7.digest = p7.digestWriter()
+	// the limit and the progress apply to the external content from here on
+	p7.progress.n = 0
+	if _, err = io.Copy(io.MultiWriter(dest, p7.digest), p7.contentReader(external)); err != nil {
+		return xerrors.Errorf("reading external content: %w", err)
 	}
-	switch pub := cert.PublicKey.(type) {
-	case *rsa.PublicKey:
-		if isRSAPSS(algo) {
-			return rsa.VerifyPSS(pub, hashType, computed, signer.EncryptedDigest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err = p7.closeDigest(); err != nil {
+		return err
+	}
+	if p7.hasContent {
+		for hash, h := range embedded {
+			if !hmac.Equal(h.Sum(nil), p7.hashes[hash].Sum(nil)) {
+				return ErrContentMismatch
+			}
 		}
-		return rsa.VerifyPKCS1v15(pub, hashType, computed, signer.EncryptedDigest)
 	}
-	return xerrors.Errorf("unsupported signature algorithm: %v", algo)
+	return p7.verifySignatures()
 }
 
-// portions Copyright 2009 The Go Authors.
-func isRSAPSS(algo x509.SignatureAlgorithm) bool {
-	switch algo {
-	case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
-		return true
-	default:
-		return false
+func (p7 *PKCS7) verifySignatures() error {
+	if err := checkDigestAlgorithms(p7.digestAlgorithmIdentifiers, p7.Signers); err != nil {
+		return err
 	}
+	for i := range p7.Signers {
+		if err := p7.verifySignature(i); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// VerifyTo parses underlying message stream and writes extracted content into writer
-func (p7 *PKCS7) VerifyTo(dest io.Writer) error {
+// readContentType reads the content type of the outer ContentInfo, failing
+// with ErrNotSignedData unless it is id-signedData
+func (p7 *PKCS7) readContentType(class int, constructed bool, tag int, length int) error {
+	if err := p7.r._object(&p7.contentType, "")(class, constructed, tag, length); err != nil {
+		return xerrors.Errorf("content type: %w", err)
+	}
+	return checkSignedDataType(p7.contentType)
+}
+
+// decode reads the message stream writing the embedded content into dest.
+// With requireContent a message without embedded content fails with
+// ErrDetachedContent as soon as its encapContentInfo ends, before the
+// certificates and signer infos are read.
+func (p7 *PKCS7) decode(dest io.Writer, requireContent bool) error {
 	br := p7.r
-	var version int
 	var contentType asn1.ObjectIdentifier
 	var certificates rawCertificates
-	err := br.readBER(
-		br.oid(oidSignedData,
+	return br.readBER(
+		br._sequence(p7.readContentType,
 			br.optional(0,
 				br.sequence(
-					br.object(&version, ""),
+					br.object(&p7.version, ""),
 					p7.initHashes,
 					br.sequence(
 						br.object(&contentType, ""),
@@ -130,6 +376,7 @@ func (p7 *PKCS7) VerifyTo(dest io.Writer) error {
 							),
 						),
 					),
+					p7.checkContent(requireContent),
 					br.raw(0, true, func(data []byte) error {
 						certificates.Raw = data
 						certs, err := certificates.Parse()
@@ -140,18 +387,26 @@ func (p7 *PKCS7) VerifyTo(dest io.Writer) error {
 						return nil
 					}),
 					br.raw(1, true, func(data []byte) error {
-						_, err := asn1.UnmarshalWithParams(data, &p7.CRLs, "optional,tag:1")
-						return xerrors.Errorf("unmarshaling CRLs: %w", err)
+						if _, err := asn1.UnmarshalWithParams(data, &p7.CRLs, "optional,tag:1"); err != nil {
+							return xerrors.Errorf("unmarshaling CRLs: %w", err)
+						}
+						return nil
 					}),
 					br.object(&p7.Signers, "set"),
 				),
 			),
 		),
 	)
-	for i := range p7.Signers {
-		if err := p7.verifySignature(i); err != nil {
-			return err
+}
+
+// checkContent fails with ErrDetachedContent if requireContent is set and no
+// content has been read. It consumes no object, the next continuation receives
+// the object it was called with.
+func (p7 *PKCS7) checkContent(requireContent bool) continuation {
+	return func(class int, constructed bool, tag int, length int) error {
+		if requireContent && !p7.hasContent {
+			return ErrDetachedContent
 		}
+		return errConditionNotMet
 	}
-	return err
 }
@@ -0,0 +1,219 @@
+package pkcs7
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	oidECPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+	oidDHSinglePassStdDHSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 2}
+	oidDHSinglePassStdDHSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+	oidDHSinglePassStdDHSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 2}
+	oidDHSinglePassStdDHSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 3}
+
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+type keyAgreeRecipientInfo struct {
+	Version                int
+	Originator             asn1.RawValue // [0] EXPLICIT OriginatorIdentifierOrKey
+	UKM                    []byte        `asn1:"explicit,optional,tag:1"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	RecipientEncryptedKeys []recipientEncryptedKey
+}
+
+type originatorPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type recipientEncryptedKey struct {
+	RID          issuerAndSerial
+	EncryptedKey []byte
+}
+
+type eccCMSSharedInfo struct {
+	KeyInfo     pkix.AlgorithmIdentifier
+	EntityUInfo []byte `asn1:"explicit,optional,tag:0"`
+	SuppPubInfo []byte `asn1:"explicit,tag:2"`
+}
+
+func getHashForKeyAgreementOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDHSinglePassStdDHSHA1KDF):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDHSinglePassStdDHSHA256KDF):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDHSinglePassStdDHSHA384KDF):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDHSinglePassStdDHSHA512KDF):
+		return crypto.SHA512, nil
+	}
+	return crypto.Hash(0), xerrors.Errorf("getting hash for key agreement OID: %w", ErrUnsupportedAlgorithm)
+}
+
+func getOIDForNamedCurve(curve elliptic.Curve) (asn1.ObjectIdentifier, error) {
+	switch curve {
+	case elliptic.P256():
+		return oidNamedCurveP256, nil
+	case elliptic.P384():
+		return oidNamedCurveP384, nil
+	case elliptic.P521():
+		return oidNamedCurveP521, nil
+	}
+	return nil, xerrors.Errorf("getting OID for curve: %w", ErrUnsupportedAlgorithm)
+}
+
+// ecdhKEK derives the key encryption key from the shared secret with the ANSI
+// X9.63 KDF as specified in RFC 5753 section 7.2
+func ecdhKEK(h crypto.Hash, shared []byte, wrap asn1.ObjectIdentifier, kekLen int, ukm []byte) ([]byte, error) {
+	suppPubInfo := make([]byte, 4)
+	binary.BigEndian.PutUint32(suppPubInfo, uint32(kekLen*8))
+	sharedInfo, err := asn1.Marshal(eccCMSSharedInfo{
+		KeyInfo:     pkix.AlgorithmIdentifier{Algorithm: wrap},
+		EntityUInfo: ukm,
+		SuppPubInfo: suppPubInfo,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling ECC shared info: %w", err)
+	}
+	var kek []byte
+	counter := make([]byte, 4)
+	for i := uint32(1); len(kek) < kekLen; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+		hasher := h.New()
+		hasher.Write(shared)
+		hasher.Write(counter)
+		hasher.Write(sharedInfo)
+		kek = hasher.Sum(kek)
+	}
+	return kek[:kekLen], nil
+}
+
+// ecdhSharedSecret returns the x coordinate of the shared point padded to the
+// curve size
+func ecdhSharedSecret(curve elliptic.Curve, x, y *big.Int, d []byte) []byte {
+	sx, _ := curve.ScalarMult(x, y, d)
+	shared := make([]byte, (curve.Params().BitSize+7)/8)
+	b := sx.Bytes()
+	copy(shared[len(shared)-len(b):], b)
+	return shared
+}
+
+// encryptKeyAgree delivers key to the EC recipient through an ephemeral-static
+// ECDH key agreement, wrapping it with AES key wrap
+func encryptKeyAgree(key []byte, recipient *x509.Certificate, pub *ecdsa.PublicKey) (asn1.RawValue, error) {
+	curveOID, err := getOIDForNamedCurve(pub.Curve)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	wrap := oidKeyWrapAES128
+	kekLen := 16
+	if len(key) > 16 {
+		wrap, kekLen = oidKeyWrapAES256, 32
+	}
+	ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	if err != nil {
+		return asn1.RawValue{}, xerrors.Errorf("generating ephemeral key: %w", err)
+	}
+	shared := ecdhSharedSecret(pub.Curve, pub.X, pub.Y, ephemeral.D.Bytes())
+	kek, err := ecdhKEK(crypto.SHA256, shared, wrap, kekLen, nil)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	ias, err := cert2issuerAndSerial(recipient)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	curveParam, err := asn1.Marshal(curveOID)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	point := elliptic.Marshal(pub.Curve, ephemeral.X, ephemeral.Y)
+	originator, err := asn1.MarshalWithParams(originatorPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey, Parameters: asn1.RawValue{FullBytes: curveParam}},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: len(point) * 8},
+	}, "tag:1")
+	if err != nil {
+		return asn1.RawValue{}, xerrors.Errorf("marshaling originator key: %w", err)
+	}
+	wrapParam, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: wrap})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	data, err := asn1.MarshalWithParams(keyAgreeRecipientInfo{
+		Version:    3,
+		Originator: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: originator},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidDHSinglePassStdDHSHA256KDF,
+			Parameters: asn1.RawValue{FullBytes: wrapParam},
+		},
+		RecipientEncryptedKeys: []recipientEncryptedKey{{RID: ias, EncryptedKey: wrapped}},
+	}, "tag:1")
+	if err != nil {
+		return asn1.RawValue{}, xerrors.Errorf("marshaling key agreement recipient info: %w", err)
+	}
+	return asn1.RawValue{FullBytes: data}, nil
+}
+
+// decryptKey recovers the content encryption key wrapped for the EC private
+// key priv
+func (kari keyAgreeRecipientInfo) decryptKey(encryptedKey []byte, priv *ecdsa.PrivateKey) ([]byte, error) {
+	h, err := getHashForKeyAgreementOID(kari.KeyEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	var wrap pkix.AlgorithmIdentifier
+	if _, err = asn1.Unmarshal(kari.KeyEncryptionAlgorithm.Parameters.FullBytes, &wrap); err != nil {
+		return nil, xerrors.Errorf("parsing key wrap algorithm: %w", err)
+	}
+	var kekLen int
+	switch {
+	case wrap.Algorithm.Equal(oidKeyWrapAES128):
+		kekLen = 16
+	case wrap.Algorithm.Equal(oidKeyWrapAES192):
+		kekLen = 24
+	case wrap.Algorithm.Equal(oidKeyWrapAES256):
+		kekLen = 32
+	default:
+		return nil, xerrors.Errorf("key wrap algorithm %v: %w", wrap.Algorithm, ErrUnsupportedAlgorithm)
+	}
+	var choice asn1.RawValue
+	if _, err = asn1.Unmarshal(kari.Originator.Bytes, &choice); err != nil {
+		return nil, xerrors.Errorf("parsing originator: %w", err)
+	}
+	if choice.Class != asn1.ClassContextSpecific || choice.Tag != 1 {
+		return nil, xerrors.Errorf("originator without public key: %w", ErrUnsupportedAlgorithm)
+	}
+	var originator originatorPublicKey
+	if _, err = asn1.UnmarshalWithParams(choice.FullBytes, &originator, "tag:1"); err != nil {
+		return nil, xerrors.Errorf("parsing originator key: %w", err)
+	}
+	x, y := elliptic.Unmarshal(priv.Curve, originator.PublicKey.RightAlign())
+	if x == nil {
+		return nil, xerrors.New("pkcs7: invalid originator public key")
+	}
+	shared := ecdhSharedSecret(priv.Curve, x, y, priv.D.Bytes())
+	kek, err := ecdhKEK(h, shared, wrap.Algorithm, kekLen, kari.UKM)
+	if err != nil {
+		return nil, err
+	}
+	return aesKeyUnwrap(kek, encryptedKey)
+}
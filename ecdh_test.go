@@ -0,0 +1,174 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestECCertificate(curve elliptic.Curve) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "Arya Stark"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageKeyAgreement,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, priv, nil
+}
+
+func TestEncryptDecryptECDH(t *testing.T) {
+	rsaCert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("Hello Secret World!")
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		ecCert, ecKey, err := createTestECCertificate(curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mode := range []int{EncryptionAlgorithmAES128GCM, EncryptionAlgorithmAES128CBC, EncryptionAlgorithmAES256CBC} {
+			encrypted, err := EncryptWithAlgorithm(plaintext, []*x509.Certificate{rsaCert.Certificate, ecCert}, mode)
+			if err != nil {
+				t.Fatalf("%s mode %d: %v", curve.Params().Name, mode, err)
+			}
+			p7, err := Parse(encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := p7.Version(); v != 2 {
+				t.Errorf("expected version 2, got %d", v)
+			}
+			result, err := p7.Decrypt(ecCert, ecKey)
+			if err != nil {
+				t.Fatalf("%s mode %d: %v", curve.Params().Name, mode, err)
+			}
+			if !bytes.Equal(plaintext, result) {
+				t.Errorf("%s mode %d: decrypted data does not match plaintext", curve.Params().Name, mode)
+			}
+			if result, err = p7.Decrypt(rsaCert.Certificate, rsaCert.PrivateKey); err != nil || !bytes.Equal(plaintext, result) {
+				t.Errorf("%s mode %d: RSA recipient failed: %v", curve.Params().Name, mode, err)
+			}
+		}
+	}
+}
+
+func TestEncryptECDHWithDES(t *testing.T) {
+	ecCert, _, err := createTestECCertificate(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = EncryptWithAlgorithm([]byte("Hello Secret World!"), []*x509.Certificate{ecCert}, EncryptionAlgorithmDESCBC)
+	if err != ErrDESKeyAgreement {
+		t.Errorf("expected ErrDESKeyAgreement, got %v", err)
+	}
+}
+
+func TestEncryptECDHOpenSSL(t *testing.T) {
+	cert, key, err := createTestECCertificate(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("Hello OpenSSL")
+	encrypted, err := EncryptWithAlgorithm(plaintext, []*x509.Certificate{cert}, EncryptionAlgorithmAES256CBC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "pkcs7-ecdh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"cert.pem":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		"key.pem":   pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		"enveloped": encrypted,
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := exec.Command("openssl", "cms", "-decrypt", "-binary", "-inform", "DER",
+		"-in", filepath.Join(dir, "enveloped"),
+		"-recip", filepath.Join(dir, "cert.pem"),
+		"-inkey", filepath.Join(dir, "key.pem")).Output()
+	if err != nil {
+		t.Fatalf("openssl command failed with %s", err)
+	}
+	if !bytes.Equal(plaintext, out) {
+		t.Errorf("openssl decrypted %q, expected %q", out, plaintext)
+	}
+}
+
+func TestEncryptWithOriginator(t *testing.T) {
+	sender, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := createTestECCertificate(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("Hello Secret World!")
+	encrypted, err := EncryptWithOriginator(plaintext, []*x509.Certificate{cert}, EncryptionAlgorithmAES128CBC, []*x509.Certificate{sender.Certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Certificates) != 1 || !p7.Certificates[0].Equal(sender.Certificate) {
+		t.Errorf("expected originator certificate, got %v", p7.Certificates)
+	}
+	if v := p7.Version(); v != 2 {
+		t.Errorf("expected version 2, got %d", v)
+	}
+	result, err := p7.Decrypt(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, result) {
+		t.Errorf("decrypted data does not match plaintext")
+	}
+
+	encrypted, err = EncryptWithAlgorithm(plaintext, []*x509.Certificate{cert}, EncryptionAlgorithmAES128CBC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p7, err = Parse(encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Certificates) != 0 {
+		t.Errorf("expected no originator certificates, got %d", len(p7.Certificates))
+	}
+}
@@ -1,75 +1,340 @@
 package pkcs7
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"hash"
 	"io"
-	"time"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/xerrors"
 )
 
 // NewEncoder creates stream PKCS signer
 func NewEncoder(w io.Writer) *SignedData {
 	res := &SignedData{
-		w: &berWriter{w},
+		w: &berWriter{Writer: w},
 	}
-	res.sd.ContentInfo.ContentType = oidSignedData
+	res.sd.ContentInfo.ContentType = oidData
 	return res
 }
 
-func (sd *SignedData) sign(r io.Reader, size int) continuation {
+// Reset prepares the encoder for signing another message into w, so that
+// signing servers can reuse it instead of allocating a new one per message.
+// With keepSigners the signers and certificates added so far are kept and
+// their hashes are reused, otherwise they are cleared and new signers have to
+// be added. Detach and Compress have to be called again for every message. A
+// PEM encoder keeps writing PEM into w. Reset makes a SignedData created by
+// NewSignedData a streaming encoder.
+func (sd *SignedData) Reset(w io.Writer, keepSigners bool) {
+	if sd.w == nil {
+		sd.w = &berWriter{}
+	}
+	if pw, ok := sd.w.Writer.(*pemWriter); ok {
+		w = newPEMWriter(w, pw.blockType)
+	}
+	sd.w.Writer = w
+	sd.sd.Version = 0
+	sd.sd.ContentInfo = contentInfo{ContentType: oidData}
+	sd.messageDigest = nil
+	sd.content = nil
+	sd.detached = false
+	sd.compress = false
+	if keepSigners {
+		for _, h := range sd.hashes {
+			h.Reset()
+		}
+		return
+	}
+	sd.sd.DigestAlgorithmIdentifiers = sd.sd.DigestAlgorithmIdentifiers[:0]
+	sd.sd.Certificates = rawCertificates{}
+	sd.sd.CRLs = nil
+	sd.sd.SignerInfos = sd.sd.SignerInfos[:0]
+	sd.certs = sd.certs[:0]
+	sd.pkeys = sd.pkeys[:0]
+	sd.configs = sd.configs[:0]
+	sd.hashes = nil
+}
+
+// sign writes the content read from r and signs the hashes once wait reports
+// that they are complete
+func (sd *SignedData) sign(r io.Reader, size int, wait func() error) continuation {
 	return sd.w.explicit(4, size, func(class int, constructed bool, _ int, _ int) (err error) {
 		r = io.LimitReader(r, int64(size))
 		if _, err = io.Copy(sd.w, r); err != nil {
 			return
 		}
-		for i, si := range sd.sd.SignerInfos {
-			hash, err := getHashForOID(si.DigestAlgorithm.Algorithm)
-			if err != nil {
-				return err
-			}
-			messageDigest := sd.hashes[hash].Sum(nil)
-			attrs := &attributes{}
-			attrs.Add(oidAttributeContentType, sd.sd.ContentInfo.ContentType)
-			attrs.Add(oidAttributeMessageDigest, messageDigest)
-			attrs.Add(oidAttributeSigningTime, time.Now())
-			finalAttrs, err := attrs.ForMarshaling()
-			if err != nil {
-				return err
-			}
-			signature, err := signAttributes(finalAttrs, sd.pkeys[i], crypto.SHA256)
-			if err != nil {
-				return err
-			}
-			sd.sd.SignerInfos[i].AuthenticatedAttributes = finalAttrs
-			sd.sd.SignerInfos[i].EncryptedDigest = signature
+		if err = wait(); err != nil {
+			return
 		}
-		return
+		return sd.signHashes()
 	})
 }
 
-func (sd *SignedData) initHashes(r io.Reader) (io.Reader, error) {
-	sd.hashes = make(map[crypto.Hash]hash.Hash)
+// signDetached digests the content without writing it, so that the
+// encapsulated content info carries no eContent
+func (sd *SignedData) signDetached(r io.Reader, size int, wait func() error) continuation {
+	return func(class int, constructed bool, _ int, _ int) (err error) {
+		if _, err = io.Copy(ioutil.Discard, io.LimitReader(r, int64(size))); err != nil {
+			return
+		}
+		if err = wait(); err != nil {
+			return
+		}
+		return sd.signHashes()
+	}
+}
+
+// signHashes signs the attributes of every signer once the content is digested
+func (sd *SignedData) signHashes() error {
+	for i, si := range sd.sd.SignerInfos {
+		hash, err := getHashForOID(si.DigestAlgorithm.Algorithm)
+		if err != nil {
+			return err
+		}
+		messageDigest := sd.hashes[hash].Sum(nil)
+		finalAttrs, signature, err := sd.signMessageDigest(messageDigest, sd.pkeys[i], sd.configs[i])
+		if err != nil {
+			return err
+		}
+		sd.sd.SignerInfos[i].AuthenticatedAttributes = finalAttrs
+		sd.sd.SignerInfos[i].EncryptedDigest = signature
+	}
+	return nil
+}
+
+// prepareHashes creates a hash for every digest algorithm used by the signers,
+// unless Reset kept one, and announces the algorithms in the message
+func (sd *SignedData) prepareHashes() error {
+	if sd.hashes == nil {
+		sd.hashes = make(map[crypto.Hash]hash.Hash)
+	}
 	for _, si := range sd.sd.SignerInfos {
 		hash, err := getHashForOID(si.DigestAlgorithm.Algorithm)
 		if err != nil {
-			return r, err
+			return err
 		}
 		if sd.hashes[hash] == nil {
-			h := hash.New()
-			sd.hashes[hash] = h
-			r = io.TeeReader(r, h)
-			sd.sd.DigestAlgorithmIdentifiers = append(sd.sd.DigestAlgorithmIdentifiers, si.DigestAlgorithm)
+			sd.hashes[hash] = hash.New()
 		}
+		sd.addDigestAlgorithm(si.DigestAlgorithm)
+	}
+	return nil
+}
+
+func (sd *SignedData) initHashes(r io.Reader) (io.Reader, error) {
+	if err := sd.prepareHashes(); err != nil {
+		return r, err
+	}
+	for _, h := range sd.hashes {
+		r = io.TeeReader(r, h)
 	}
 	return r, nil
 }
 
+// ContentDigest reads the content from r and returns its digests computed
+// with the digest algorithm of every signer added so far, or SHA-256 when
+// there is none, without signing or writing anything. The digests equal the
+// message digests SignFrom puts into the signed attributes for the same
+// content, so a remote signer can authorize them in advance. With Compress
+// the digests cover the compressed content, as the signatures do.
+func (sd *SignedData) ContentDigest(r io.Reader) (map[crypto.Hash][]byte, error) {
+	hashes := make(map[crypto.Hash]hash.Hash)
+	for _, si := range sd.sd.SignerInfos {
+		h, err := getHashForOID(si.DigestAlgorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashes[h] = h.New()
+	}
+	if len(hashes) == 0 {
+		hashes[crypto.SHA256] = crypto.SHA256.New()
+	}
+	if sd.compress {
+		var err error
+		if r, _, err = sd.compressContent(r, maxInt); err != nil {
+			return nil, err
+		}
+	}
+	ws := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		ws = append(ws, h)
+	}
+	if _, err := io.Copy(io.MultiWriter(ws...), r); err != nil {
+		return nil, xerrors.Errorf("pkcs7: hashing content: %w", err)
+	}
+	res := make(map[crypto.Hash][]byte, len(hashes))
+	for hash, h := range hashes {
+		res[hash] = h.Sum(nil)
+	}
+	return res, nil
+}
+
+func hashesDone() error {
+	return nil
+}
+
+// smallContentSize is the content length up to which SignFrom buffers the
+// content and writes the whole message in DER instead of streaming it
+const smallContentSize = 1024
+
+// SignFrom reads size bytes of content from r and writes the complete signed
+// message, including the terminators of indefinite-length encodings, into the
+// encoder's writer. Content of at most smallContentSize bytes is read into
+// memory instead and the message is written in DER, which is more compact
+// and faster for small payloads such as API tokens. If the writer buffers its
+// output, call Flush afterwards.
 func (sd *SignedData) SignFrom(r io.Reader, size int) (err error) {
-	version := 1
+	if sd.compress {
+		if r, size, err = sd.compressContent(r, size); err != nil {
+			return err
+		}
+	}
+	if size >= 0 && size <= smallContentSize {
+		return sd.signSmall(r, size)
+	}
+	if r, err = sd.initHashes(r); err != nil {
+		return err
+	}
+	return sd.signFrom(r, size, hashesDone)
+}
+
+// SignFromReaderAt is like SignFrom, but reads the content from r
+// independently for every digest algorithm, computing the hashes in parallel
+// goroutines while the content is written. It speeds up signing huge files
+// with several digest algorithms on multicore machines.
+func (sd *SignedData) SignFromReaderAt(r io.ReaderAt, size int) error {
+	if sd.compress || size <= smallContentSize {
+		// the compressed or small content is hashed from memory
+		return sd.SignFrom(io.NewSectionReader(r, 0, int64(size)), size)
+	}
+	if err := sd.prepareHashes(); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(sd.hashes))
+	for _, h := range sd.hashes {
+		wg.Add(1)
+		go func(h hash.Hash) {
+			defer wg.Done()
+			if _, err := io.Copy(h, io.NewSectionReader(r, 0, int64(size))); err != nil {
+				errs <- xerrors.Errorf("pkcs7: hashing content: %w", err)
+			}
+		}(h)
+	}
+	// the hashes must not be touched after returning, even on errors
+	defer wg.Wait()
+	wait := func() error {
+		wg.Wait()
+		select {
+		case err := <-errs:
+			return err
+		default:
+			return nil
+		}
+	}
+	content := io.NewSectionReader(r, 0, int64(size))
+	if sd.detached {
+		// the goroutines above read the content, it is not written
+		return sd.signFrom(content, 0, wait)
+	}
+	return sd.signFrom(content, size, wait)
+}
+
+// compressContent reads size bytes of content from r and returns a reader of
+// its CompressedData encoding, which becomes the signed content
+func (sd *SignedData) compressContent(r io.Reader, size int) (io.Reader, int, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, 0, xerrors.Errorf("pkcs7: reading content: %w", err)
+	}
+	compressed, err := marshalCompressedData(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	sd.sd.ContentInfo.ContentType = oidCompressedData
+	return bytes.NewReader(compressed), len(compressed), nil
+}
+
+func (sd *SignedData) signFrom(r io.Reader, size int, wait func() error) (err error) {
+	content := sd.w.optional(0, sd.sign(r, size, wait))
+	if sd.detached {
+		content = sd.signDetached(r, size, wait)
+	}
+	if err = sd.writeMessage(content); err != nil {
+		return err
+	}
+	return sd.closePEM()
+}
+
+// signSmall reads the whole content of size bytes, signs it and writes the
+// message in DER
+func (sd *SignedData) signSmall(r io.Reader, size int) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return xerrors.Errorf("pkcs7: reading content: %w", err)
+	}
+	if err := sd.prepareHashes(); err != nil {
+		return err
+	}
+	for _, h := range sd.hashes {
+		h.Write(data)
+	}
+	if err := sd.signHashes(); err != nil {
+		return err
+	}
+	der, err := sd.finishDER(data)
+	if err != nil {
+		return err
+	}
+	if _, err = sd.w.Write(der); err != nil {
+		return err
+	}
+	return sd.closePEM()
+}
+
+// finishDER encodes the message with data as its content, or without content
+// when detached
+func (sd *SignedData) finishDER(data []byte) ([]byte, error) {
+	sd.sd.Version = sd.version()
+	if !sd.detached {
+		content, err := asn1.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		sd.sd.ContentInfo.Content = asn1.RawValue{Class: 2, Tag: 0, Bytes: content, IsCompound: true}
+	}
+	return sd.Finish()
+}
+
+func (sd *SignedData) closePEM() error {
+	if pw, ok := sd.w.Writer.(*pemWriter); ok {
+		return pw.Close()
+	}
+	return nil
+}
+
+func (sd *SignedData) version() int {
+	if !sd.sd.ContentInfo.ContentType.Equal(oidData) {
+		return 3
+	}
+	return 1
+}
+
+// writeMessage writes the SignedData around the encapsulated content written
+// by content
+func (sd *SignedData) writeMessage(content continuation) error {
+	version := sd.version()
 	w := sd.w
 	sd.sd.Certificates = marshalCertificates(sd.certs)
-	if r, err = sd.initHashes(r); err != nil {
-		return nil
+	if err := sd.sortDigestAlgorithms(); err != nil {
+		return err
 	}
 	return w.writeBER(
 		w.oid(oidSignedData,
@@ -77,12 +342,7 @@ func (sd *SignedData) SignFrom(r io.Reader, size int) (err error) {
 				w.sequence(
 					w.object(version, ""),
 					w.object(sd.sd.DigestAlgorithmIdentifiers, "set"),
-					w.oid(
-						oidData,
-						w.optional(0,
-							sd.sign(r, size),
-						),
-					),
+					w.oid(sd.sd.ContentInfo.ContentType, content),
 					w.raw(0, sd.sd.Certificates.Raw),
 					w.object(sd.sd.CRLs, "optional,tag:1"),
 					w.object(sd.sd.SignerInfos, "set"),
@@ -91,3 +351,163 @@ func (sd *SignedData) SignFrom(r io.Reader, size int) (err error) {
 		),
 	)
 }
+
+// EstimatedSize returns the number of bytes SignFrom will write for
+// contentLength bytes of content with the signers and certificates added so
+// far, e.g. to set Content-Length before streaming. The encoder writes the
+// containers with indefinite lengths and the content with a definite one, or
+// everything in DER for small content, so the estimate is exact for RSA
+// signers. The DER encoding of an ECDSA
+// signature varies in length, so for ECDSA signers the estimate is an upper
+// bound, usually one or two bytes over per signer. PEM armor is accounted for.
+// The size of compressed content cannot be known in advance, so Compress
+// makes it fail.
+func (sd *SignedData) EstimatedSize(contentLength int) (int64, error) {
+	if sd.compress {
+		return 0, xerrors.New("pkcs7: the size of compressed content cannot be estimated")
+	}
+	if contentLength < 0 {
+		return 0, xerrors.Errorf("pkcs7: invalid content length %d", contentLength)
+	}
+	counter := &countingWriter{}
+	est := *sd
+	est.w = &berWriter{Writer: counter}
+	est.sd.DigestAlgorithmIdentifiers = append([]pkix.AlgorithmIdentifier(nil), sd.sd.DigestAlgorithmIdentifiers...)
+	est.sd.SignerInfos = make([]signerInfo, len(sd.sd.SignerInfos))
+	for i, si := range sd.sd.SignerInfos {
+		hash, err := getHashForOID(si.DigestAlgorithm.Algorithm)
+		if err != nil {
+			return 0, err
+		}
+		if !sd.configs[i].NoAttributes {
+			if si.AuthenticatedAttributes, err = sd.signedAttributes(make([]byte, hash.Size()), sd.configs[i]); err != nil {
+				return 0, err
+			}
+		}
+		n, err := maxSignatureSize(sd.pkeys[i])
+		if err != nil {
+			return 0, err
+		}
+		si.EncryptedDigest = make([]byte, n)
+		est.sd.SignerInfos[i] = si
+	}
+	if contentLength <= smallContentSize {
+		der, err := est.finishDER(make([]byte, contentLength))
+		if err != nil {
+			return 0, err
+		}
+		return sd.armoredSize(int64(len(der))), nil
+	}
+	content := est.w.optional(0, est.w.explicit(4, contentLength, func(int, bool, int, int) error { return nil }))
+	if sd.detached {
+		content = func(int, bool, int, int) error { return nil }
+	}
+	if err := est.writeMessage(content); err != nil {
+		return 0, err
+	}
+	size := counter.n
+	if !sd.detached {
+		size += int64(contentLength)
+	}
+	return sd.armoredSize(size), nil
+}
+
+// armoredSize returns the number of bytes the writer of the encoder emits for
+// size bytes of message
+func (sd *SignedData) armoredSize(size int64) int64 {
+	if pw, ok := sd.w.Writer.(*pemWriter); ok {
+		return pw.armoredSize(size)
+	}
+	return size
+}
+
+// maxSignatureSize returns the longest signature pkey produces
+func maxSignatureSize(pkey crypto.PrivateKey) (int, error) {
+	switch k := signerPublicKey(pkey).(type) {
+	case *rsa.PublicKey:
+		return k.Size(), nil
+	case *ecdsa.PublicKey:
+		// SEQUENCE of two INTEGERs, each with a leading zero byte at most
+		n := (k.Curve.Params().N.BitLen()+7)/8 + 1
+		ints := 2 * (1 + encodedLengthLen(n) + n)
+		return 1 + encodedLengthLen(ints) + ints, nil
+	}
+	return 0, xerrors.Errorf("signer key %T: %w", pkey, ErrUnsupportedPublicKeyAlgorithm)
+}
+
+// countingWriter discards what is written to it, counting the bytes
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// EncodeDegenerateCertificates streams a certs-only SignedData, which has no
+// content and no signers, into w. It calls next for the DER encoding of every
+// certificate until next returns io.EOF, writing each one as it comes, so that
+// large bundles are never held in memory.
+func EncodeDegenerateCertificates(w io.Writer, next func() ([]byte, error)) error {
+	bw := &berWriter{Writer: w}
+	certs := bw.optional(0, func(class int, constructed bool, tag int, length int) error {
+		for {
+			cert, err := next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			var raw asn1.RawValue
+			if rest, err := asn1.Unmarshal(cert, &raw); err != nil || len(rest) > 0 || raw.Tag != asn1.TagSequence {
+				return xerrors.New("pkcs7: certificate is not a single DER encoded SEQUENCE")
+			}
+			if _, err = bw.Write(cert); err != nil {
+				return xerrors.Errorf("pkcs7: writing certificate: %w", err)
+			}
+		}
+	})
+	return bw.writeBER(
+		bw.oid(oidSignedData,
+			bw.optional(0,
+				bw.sequence(
+					bw.object(1, ""),
+					bw.object([]pkix.AlgorithmIdentifier{}, "set"),
+					bw.sequence(bw.object(oidData, "")),
+					certs,
+					bw.object([]signerInfo{}, "set"),
+				),
+			),
+		),
+	)
+}
+
+// SignFile streams the content of the file at path into the signed message
+// without loading it into memory
+func (sd *SignedData) SignFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return xerrors.Errorf("pkcs7: opening content file: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return xerrors.Errorf("pkcs7: reading content file size: %w", err)
+	}
+	if fi.Size() > int64(maxInt) {
+		return xerrors.Errorf("pkcs7: content file %s is too large", path)
+	}
+	return sd.SignFromReaderAt(f, int(fi.Size()))
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// Flush flushes the underlying writer if it implements a Flush method, such
+// as bufio.Writer does
+func (sd *SignedData) Flush() error {
+	if f, ok := sd.w.Writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package pkcs7
+
+import (
+	"crypto/x509"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// gcmChunkSize is the size of the chunks the content is read in and of
+	// the OCTET STRING fragments the ciphertext is written as
+	gcmChunkSize = 64 * 1024
+	// maxGCMContentSize is the longest plaintext GCM can seal with a single
+	// nonce, 2^32-2 blocks
+	maxGCMContentSize = 1<<36 - 32
+	// defaultMaxBufferSize is the content size EncryptFrom accepts when
+	// EnvelopeEncoder.MaxBufferSize is not set
+	defaultMaxBufferSize = 256 << 20
+)
+
+// EnvelopeEncoder writes AuthEnvelopedData (RFC 5083) for content read from
+// a stream. It is not a streaming encrypter: the whole content is buffered,
+// up to MaxBufferSize, before anything is written.
+type EnvelopeEncoder struct {
+	// MaxBufferSize is the largest content EncryptFrom buffers, zero means
+	// 256 MiB. It cannot raise the limit above what AES-GCM can seal.
+	MaxBufferSize int64
+
+	w          *berWriter
+	recipients []*x509.Certificate
+	algorithm  int
+	config     EnvelopeConfig
+}
+
+// NewEnvelopeEncoder creates a PKCS #7 encrypter for recipients writing into
+// w. Only EncryptionAlgorithmAES128GCM is supported, other algorithms make
+// EncryptFrom return ErrUnsupportedEncryptionAlgorithm.
+// config.AuthenticatedAttributes are authenticated along with the content.
+func NewEnvelopeEncoder(w io.Writer, recipients []*x509.Certificate, algorithm int, config EnvelopeConfig) *EnvelopeEncoder {
+	return &EnvelopeEncoder{
+		w:          &berWriter{Writer: w},
+		recipients: recipients,
+		algorithm:  algorithm,
+		config:     config,
+	}
+}
+
+// EncryptFrom encrypts size bytes of content read from r and writes the
+// envelope.
+//
+// GCM authenticates the whole content with a single tag and the standard
+// library only seals complete messages, so the content is read in chunks of
+// gcmChunkSize into one buffer of its final size and sealed in place by a
+// single GCM context, with the DER of the authenticated attributes as the
+// additional data. Only then is the ciphertext written as OCTET STRING
+// fragments of gcmChunkSize, followed by the tag as the mac field, as in the
+// single-shot CMS model. The whole content is held in memory, so size above
+// MaxBufferSize or the GCM limit of 2^36-32 bytes is rejected with a wrapped
+// ErrContentTooLarge before anything is allocated or read.
+func (e *EnvelopeEncoder) EncryptFrom(r io.Reader, size int) error {
+	if e.algorithm != EncryptionAlgorithmAES128GCM {
+		return ErrUnsupportedEncryptionAlgorithm
+	}
+	if e.config.IV != nil {
+		return xerrors.New("pkcs7: caller supplied IV is not supported for AES-GCM, reusing a nonce breaks it")
+	}
+	if size < 0 {
+		return xerrors.Errorf("pkcs7: invalid content size %d", size)
+	}
+	if max := e.maxBufferSize(); int64(size) > max {
+		return xerrors.Errorf("pkcs7: %d bytes exceed the buffer limit of %d: %w", size, max, ErrContentTooLarge)
+	}
+	key, nonce, gcm, err := newAESGCM()
+	if err != nil {
+		return err
+	}
+	alg, err := aesGCMAlgorithm(nonce, gcm.Overhead())
+	if err != nil {
+		return err
+	}
+	authAttrs, additionalData, err := authAttributes(oidData, e.config)
+	if err != nil {
+		return err
+	}
+	eci := encryptedContentInfo{
+		ContentType:                oidData,
+		ContentEncryptionAlgorithm: alg,
+	}
+	envelope, err := newAuthEnvelope(eci, key, e.recipients, authAttrs, e.config)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, size, size+gcm.Overhead())
+	for off := 0; off < size; off += gcmChunkSize {
+		end := off + gcmChunkSize
+		if end > size {
+			end = size
+		}
+		if _, err = io.ReadFull(r, buf[off:end]); err != nil {
+			return xerrors.Errorf("pkcs7: reading content: %w", err)
+		}
+	}
+	sealed := gcm.Seal(buf[:0], nonce, buf, additionalData)
+
+	w := e.w
+	return w.writeBER(
+		w.oid(oidAuthEnvelopedData,
+			w.optional(0,
+				w.sequence(
+					w.object(envelope.Version, ""),
+					w.object(envelope.OriginatorInfo, "optional,tag:0"),
+					w.object(envelope.RecipientInfos, "set"),
+					w.sequence(
+						w.object(eci.ContentType, ""),
+						w.object(eci.ContentEncryptionAlgorithm, ""),
+						w.optional(0, e.fragments(sealed[:size])),
+					),
+					w.raw(1, authAttrs),
+					w.object(sealed[size:], ""),
+					w.object(envelope.UnauthAttrs, "optional,set,tag:2"),
+				),
+			),
+		),
+	)
+}
+
+// maxBufferSize returns MaxBufferSize or its default, capped at the AES-GCM
+// limit
+func (e *EnvelopeEncoder) maxBufferSize() int64 {
+	max := e.MaxBufferSize
+	if max <= 0 {
+		max = defaultMaxBufferSize
+	}
+	if max > maxGCMContentSize {
+		max = maxGCMContentSize
+	}
+	return max
+}
+
+// fragments writes data as primitive OCTET STRINGs of at most gcmChunkSize
+// bytes
+func (e *EnvelopeEncoder) fragments(data []byte) continuation {
+	return func(class int, constructed bool, tag int, length int) (err error) {
+		for len(data) > 0 {
+			n := len(data)
+			if n > gcmChunkSize {
+				n = gcmChunkSize
+			}
+			if _, err = e.w.Write(appendMeta(e.w.meta[:0], 0, false, 4, n)); err != nil {
+				return
+			}
+			if _, err = e.w.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package pkcs7
+
+import (
+	"encoding/asn1"
+
+	"golang.org/x/xerrors"
+)
+
+var oidAttributeReceiptRequest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 1}
+
+// Values of ReceiptRequest.AllOrFirstTier
+const (
+	ReceiptsFromAll = iota
+	ReceiptsFromFirstTier
+)
+
+// ReceiptRequest is the ESS receiptRequest signed attribute of RFC 2634
+// asking the recipients to return a signed receipt
+type ReceiptRequest struct {
+	SignedContentIdentifier []byte
+	// AllOrFirstTier selects who should return receipts when ReceiptList is
+	// empty, either ReceiptsFromAll or ReceiptsFromFirstTier
+	AllOrFirstTier int
+	// ReceiptList holds the DER encoded GeneralNames of the recipients that
+	// should return receipts
+	ReceiptList []asn1.RawValue
+	// ReceiptsTo holds the DER encoded GeneralNames receipts are sent to
+	ReceiptsTo []asn1.RawValue
+}
+
+type receiptRequest struct {
+	SignedContentIdentifier []byte
+	ReceiptsFrom            asn1.RawValue
+	ReceiptsTo              []asn1.RawValue
+}
+
+// Attribute returns the receipt request as a signed attribute to be included
+// with SignerInfoConfig.ExtraSignedAttributes
+func (rr ReceiptRequest) Attribute() (Attribute, error) {
+	if len(rr.ReceiptsTo) == 0 {
+		return Attribute{}, xerrors.New("pkcs7: receipt request has no receiptsTo")
+	}
+	raw := receiptRequest{
+		SignedContentIdentifier: rr.SignedContentIdentifier,
+		ReceiptsTo:              rr.ReceiptsTo,
+	}
+	if len(rr.ReceiptList) > 0 {
+		var list []byte
+		for _, names := range rr.ReceiptList {
+			list = append(list, names.FullBytes...)
+		}
+		raw.ReceiptsFrom = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: list}
+	} else {
+		var tier asn1.RawValue
+		encoded, err := asn1.Marshal(rr.AllOrFirstTier)
+		if err != nil {
+			return Attribute{}, xerrors.Errorf("pkcs7: marshaling allOrFirstTier: %w", err)
+		}
+		if _, err = asn1.Unmarshal(encoded, &tier); err != nil {
+			return Attribute{}, xerrors.Errorf("pkcs7: marshaling allOrFirstTier: %w", err)
+		}
+		raw.ReceiptsFrom = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: tier.Bytes}
+	}
+	return Attribute{Type: oidAttributeReceiptRequest, Value: raw}, nil
+}
+
+// ReceiptRequest returns the receipt request signed attribute of the signer at
+// index
+func (p7 *PKCS7) ReceiptRequest(index int) (*ReceiptRequest, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	var raw receiptRequest
+	if err = unmarshalAttribute(signer.AuthenticatedAttributes, oidAttributeReceiptRequest, &raw); err != nil {
+		return nil, xerrors.Errorf("pkcs7: receipt request: %w", err)
+	}
+	rr := &ReceiptRequest{
+		SignedContentIdentifier: raw.SignedContentIdentifier,
+		ReceiptsTo:              raw.ReceiptsTo,
+	}
+	switch {
+	case raw.ReceiptsFrom.Class == asn1.ClassContextSpecific && raw.ReceiptsFrom.Tag == 0:
+		var tier int
+		if _, err = asn1.UnmarshalWithParams(raw.ReceiptsFrom.FullBytes, &tier, "tag:0"); err != nil {
+			return nil, xerrors.Errorf("pkcs7: receipt request allOrFirstTier: %w", err)
+		}
+		rr.AllOrFirstTier = tier
+	case raw.ReceiptsFrom.Class == asn1.ClassContextSpecific && raw.ReceiptsFrom.Tag == 1:
+		if _, err = asn1.UnmarshalWithParams(raw.ReceiptsFrom.FullBytes, &rr.ReceiptList, "tag:1"); err != nil {
+			return nil, xerrors.Errorf("pkcs7: receipt request receiptList: %w", err)
+		}
+	default:
+		return nil, xerrors.New("pkcs7: receipt request has invalid receiptsFrom")
+	}
+	return rr, nil
+}
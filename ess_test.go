@@ -0,0 +1,83 @@
+package pkcs7
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func testGeneralNames(t *testing.T, email string) asn1.RawValue {
+	// GeneralNames with a single rfc822Name
+	encoded, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte(email)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names asn1.RawValue
+	if _, err = asn1.Unmarshal(encoded, &names); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestReceiptRequest(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rr := range []ReceiptRequest{
+		{
+			SignedContentIdentifier: []byte("content-1"),
+			AllOrFirstTier:          ReceiptsFromFirstTier,
+			ReceiptsTo:              []asn1.RawValue{testGeneralNames(t, "sender@example.com")},
+		},
+		{
+			SignedContentIdentifier: []byte("content-2"),
+			ReceiptList:             []asn1.RawValue{testGeneralNames(t, "alice@example.com"), testGeneralNames(t, "bob@example.com")},
+			ReceiptsTo:              []asn1.RawValue{testGeneralNames(t, "sender@example.com")},
+		},
+	} {
+		attr, err := rr.Attribute()
+		if err != nil {
+			t.Fatal(err)
+		}
+		toBeSigned, err := NewSignedData([]byte("Hello World"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{ExtraSignedAttributes: []Attribute{attr}}); err != nil {
+			t.Fatal(err)
+		}
+		signed, err := toBeSigned.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p7, err := Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p7.Verify(); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := p7.ReceiptRequest(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(parsed.SignedContentIdentifier, rr.SignedContentIdentifier) || parsed.AllOrFirstTier != rr.AllOrFirstTier {
+			t.Errorf("expected %+v, got %+v", rr, parsed)
+		}
+		if len(parsed.ReceiptList) != len(rr.ReceiptList) || len(parsed.ReceiptsTo) != len(rr.ReceiptsTo) {
+			t.Fatalf("expected %+v, got %+v", rr, parsed)
+		}
+		for i := range rr.ReceiptList {
+			if !bytes.Equal(parsed.ReceiptList[i].FullBytes, rr.ReceiptList[i].FullBytes) {
+				t.Errorf("receipt list %d: expected %x, got %x", i, rr.ReceiptList[i].FullBytes, parsed.ReceiptList[i].FullBytes)
+			}
+		}
+		if !bytes.Equal(parsed.ReceiptsTo[0].FullBytes, rr.ReceiptsTo[0].FullBytes) {
+			t.Errorf("expected receiptsTo %x, got %x", rr.ReceiptsTo[0].FullBytes, parsed.ReceiptsTo[0].FullBytes)
+		}
+	}
+	if _, err = (ReceiptRequest{}).Attribute(); err == nil {
+		t.Error("expected error for missing receiptsTo")
+	}
+}
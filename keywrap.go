@@ -0,0 +1,68 @@
+package pkcs7
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultKeyWrapIV is the initial value defined in RFC 3394 section 2.2.3.1
+var defaultKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// aesKeyWrap wraps key with kek as specified in RFC 3394
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, xerrors.Errorf("key wrap: invalid key length %d", len(key))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, xerrors.Errorf("key wrap: %w", err)
+	}
+	n := len(key) / 8
+	res := make([]byte, 8+len(key))
+	copy(res, defaultKeyWrapIV)
+	copy(res[8:], key)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, res[:8])
+			copy(buf[8:], res[8*i:8*i+8])
+			block.Encrypt(buf, buf)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(res[:8], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(res[8*i:], buf[8:])
+		}
+	}
+	return res, nil
+}
+
+// aesKeyUnwrap unwraps key with kek as specified in RFC 3394
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, xerrors.Errorf("key unwrap: invalid wrapped key length %d", len(wrapped))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, xerrors.Errorf("key unwrap: %w", err)
+	}
+	n := len(wrapped)/8 - 1
+	res := make([]byte, len(wrapped))
+	copy(res, wrapped)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(res[:8])^t)
+			copy(buf[8:], res[8*i:8*i+8])
+			block.Decrypt(buf, buf)
+			copy(res[:8], buf[:8])
+			copy(res[8*i:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(res[:8], defaultKeyWrapIV) != 1 {
+		return nil, xerrors.New("key unwrap: integrity check failed")
+	}
+	return res[8:], nil
+}
@@ -0,0 +1,48 @@
+package pkcs7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAESKeyWrap(t *testing.T) {
+	// RFC 3394 section 4 test vectors
+	fixtures := []struct {
+		KEK, Key, Wrapped string
+	}{
+		{
+			"000102030405060708090A0B0C0D0E0F",
+			"00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+		},
+	}
+	for _, fixture := range fixtures {
+		kek, _ := hex.DecodeString(fixture.KEK)
+		key, _ := hex.DecodeString(fixture.Key)
+		expected, _ := hex.DecodeString(fixture.Wrapped)
+		wrapped, err := aesKeyWrap(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, wrapped) {
+			t.Errorf("Wrapped key does not match.\n\tExpected: %X\n\tActual: %X", expected, wrapped)
+		}
+		unwrapped, err := aesKeyUnwrap(kek, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, unwrapped) {
+			t.Errorf("Unwrapped key does not match.\n\tExpected: %X\n\tActual: %X", key, unwrapped)
+		}
+		wrapped[0] ^= 0xff
+		if _, err = aesKeyUnwrap(kek, wrapped); err == nil {
+			t.Error("expected integrity check error for tampered key")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package pkcs7
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"golang.org/x/xerrors"
+)
+
+// lazySignedData is signedData with the signer infos left encoded
+type lazySignedData struct {
+	Version                    int                        `asn1:"default:1"`
+	DigestAlgorithmIdentifiers []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo                contentInfo
+	Certificates               rawCertificates        `asn1:"optional,tag:0"`
+	CRLs                       []pkix.CertificateList `asn1:"optional,tag:1"`
+	SignerInfos                asn1.RawValue
+}
+
+// ParseLazySigners is like Parse for SignedData, but leaves the signer infos
+// encoded until they are needed, so that picking one signer of a huge
+// multi-signer message, e.g. a notarization bundle, does not decode all of
+// them. Signers stays empty and SignerCount tells how many signers there are.
+// The accessors taking a signer index, such as VerifySigner, SignerID and
+// SigningTime, decode only the signer at that index, every time they are
+// called. Verify and the methods working on the whole message, such as
+// WriteTo, decode all signers into Signers first.
+func ParseLazySigners(data []byte) (*PKCS7, error) {
+	if len(data) == 0 {
+		return nil, xerrors.New("pkcs7: input data is empty")
+	}
+	info, der, indefinite, err := ParseOptions{}.decodeContentInfo(data)
+	if err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, ErrUnsupportedContentType
+	}
+	var lazy lazySignedData
+	if _, err = asn1.Unmarshal(info.Content.Bytes, &lazy); err != nil {
+		return nil, err
+	}
+	if lazy.SignerInfos.Class != asn1.ClassUniversal || lazy.SignerInfos.Tag != asn1.TagSet || !lazy.SignerInfos.IsCompound {
+		return nil, asn1.StructuralError{Msg: "signerInfos is not a SET"}
+	}
+	signers, err := rawFields(lazy.SignerInfos.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	p7, err := newSignedPKCS7(signedData{
+		Version:                    lazy.Version,
+		DigestAlgorithmIdentifiers: lazy.DigestAlgorithmIdentifiers,
+		ContentInfo:                lazy.ContentInfo,
+		Certificates:               lazy.Certificates,
+		CRLs:                       lazy.CRLs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p7.lazySigners = signers
+	p7.indefiniteLength = indefinite
+	p7.der = der
+	return p7, nil
+}
+
+// SignerCount returns the number of signers of the message, including those
+// ParseLazySigners has not decoded yet
+func (p7 *PKCS7) SignerCount() int {
+	if p7.lazySigners != nil {
+		return len(p7.lazySigners)
+	}
+	return len(p7.Signers)
+}
+
+// decodeSigner decodes the signer info at index ParseLazySigners left encoded
+func (p7 *PKCS7) decodeSigner(index int) (signerInfo, error) {
+	var signer signerInfo
+	rest, err := asn1.Unmarshal(p7.lazySigners[index].FullBytes, &signer)
+	if err == nil && len(rest) > 0 {
+		err = asn1.SyntaxError{Msg: "trailing data"}
+	}
+	if err != nil {
+		return signerInfo{}, xerrors.Errorf("pkcs7: decoding signer %d: %w", index, err)
+	}
+	return signer, nil
+}
+
+// loadSigners decodes all the signer infos ParseLazySigners left encoded into
+// Signers
+func (p7 *PKCS7) loadSigners() error {
+	if p7.lazySigners == nil {
+		return nil
+	}
+	signers := make([]signerInfo, len(p7.lazySigners))
+	for i := range p7.lazySigners {
+		var err error
+		if signers[i], err = p7.decodeSigner(i); err != nil {
+			return err
+		}
+	}
+	p7.Signers = signers
+	p7.lazySigners = nil
+	if sd, ok := p7.raw.(signedData); ok {
+		sd.SignerInfos = signers
+		p7.raw = sd
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+func TestParseLazySigners(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned, err := NewSignedData([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 20
+	signingTime := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < count; i++ {
+		config := SignerInfoConfig{SigningTime: signingTime.Add(time.Duration(i) * time.Minute)}
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signed, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p7, err := ParseLazySigners(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Signers) != 0 {
+		t.Fatalf("expected no decoded signers, got %d", len(p7.Signers))
+	}
+	if p7.SignerCount() != count {
+		t.Fatalf("expected %d signers, got %d", count, p7.SignerCount())
+	}
+	if p7.Type() != MessageTypeSignedData {
+		t.Errorf("expected %v, got %v", MessageTypeSignedData, p7.Type())
+	}
+	// any attempt to decode the other signers fails
+	for i := 0; i < count-1; i++ {
+		p7.lazySigners[i] = asn1.RawValue{FullBytes: []byte{0x30, 0x00}}
+	}
+	if err = p7.VerifySigner(count - 1); err != nil {
+		t.Fatalf("last signer: %v", err)
+	}
+	if _, err = p7.SigningTime(count - 1); err != nil {
+		t.Fatalf("last signer: %v", err)
+	}
+	if _, err = p7.SignerID(0); err == nil {
+		t.Error("expected an error decoding a corrupted signer")
+	}
+	if err = p7.VerifySigner(count); err == nil {
+		t.Error("expected error for signer index out of range")
+	}
+
+	p7, err = ParseLazySigners(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p7.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Signers) != count || p7.SignerCount() != count {
+		t.Errorf("expected Verify to decode all %d signers, got %d", count, len(p7.Signers))
+	}
+	var buf bytes.Buffer
+	if _, err = p7.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), signed) {
+		t.Error("re-encoded message differs from the input")
+	}
+
+	enveloped, err := Encrypt([]byte("Hello World"), []*x509.Certificate{cert.Certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseLazySigners(enveloped); err != ErrUnsupportedContentType {
+		t.Errorf("expected ErrUnsupportedContentType for EnvelopedData, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package pkcs7
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	oidRSAESOAEP  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidPSpecified = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 9}
+)
+
+// OAEPConfig selects RSAES-OAEP key transport for RSA recipients instead of
+// PKCS #1 v1.5. See https://tools.ietf.org/html/rfc4055#section-4
+type OAEPConfig struct {
+	// Hash is the OAEP hash function, SHA-1 when zero
+	Hash crypto.Hash
+	// MGFHash is the hash function used by MGF1, the same as Hash when zero
+	MGFHash crypto.Hash
+}
+
+// rsaesOAEPParams reflects the parameters of the id-RSAES-OAEP key
+// encryption algorithm. Absent fields default to SHA-1, MGF1 with SHA-1 and
+// an empty label.
+type rsaesOAEPParams struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+func (c *OAEPConfig) hashes() (hash, mgfHash crypto.Hash) {
+	hash, mgfHash = c.Hash, c.MGFHash
+	if hash == 0 {
+		hash = crypto.SHA1
+	}
+	if mgfHash == 0 {
+		mgfHash = hash
+	}
+	return
+}
+
+// algorithm returns the key encryption algorithm identifier with the DER
+// encoded parameters, leaving out the fields that hold their default value
+func (c *OAEPConfig) algorithm() (pkix.AlgorithmIdentifier, error) {
+	hash, mgfHash := c.hashes()
+	var params rsaesOAEPParams
+	if hash != crypto.SHA1 {
+		oid, ok := HashToOID(hash)
+		if !ok {
+			return pkix.AlgorithmIdentifier{}, xerrors.Errorf("OAEP hash %v: %w", hash, ErrUnsupportedAlgorithm)
+		}
+		params.HashFunc = pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: nullBytes}}
+	}
+	if mgfHash != crypto.SHA1 {
+		oid, ok := HashToOID(mgfHash)
+		if !ok {
+			return pkix.AlgorithmIdentifier{}, xerrors.Errorf("MGF1 hash %v: %w", mgfHash, ErrUnsupportedAlgorithm)
+		}
+		mgfParams, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: nullBytes}})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, err
+		}
+		params.MaskGenFunc = pkix.AlgorithmIdentifier{
+			Algorithm:  oidMGF1,
+			Parameters: asn1.RawValue{FullBytes: mgfParams},
+		}
+	}
+	data, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oidRSAESOAEP,
+		Parameters: asn1.RawValue{FullBytes: data},
+	}, nil
+}
+
+func (c *OAEPConfig) encryptKey(key []byte, pub *rsa.PublicKey) ([]byte, error) {
+	hash, mgfHash := c.hashes()
+	return rsa.EncryptOAEPWithOptions(rand.Reader, pub, key, &rsa.OAEPOptions{Hash: hash, MGFHash: mgfHash})
+}
+
+// parseOAEPOptions reads the RSAES-OAEP parameters of a key encryption
+// algorithm, applying the defaults for the absent fields
+func parseOAEPOptions(ai pkix.AlgorithmIdentifier) (*rsa.OAEPOptions, error) {
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+	if len(ai.Parameters.FullBytes) == 0 {
+		return opts, nil
+	}
+	var params rsaesOAEPParams
+	if rest, err := asn1.Unmarshal(ai.Parameters.FullBytes, &params); err != nil {
+		return nil, xerrors.Errorf("unmarshaling OAEP parameters: %w", err)
+	} else if len(rest) > 0 {
+		return nil, xerrors.New("trailing data after OAEP parameters")
+	}
+	var err error
+	if len(params.HashFunc.Algorithm) > 0 {
+		if opts.Hash, err = getHashForOID(params.HashFunc.Algorithm); err != nil {
+			return nil, xerrors.Errorf("OAEP hash: %w", err)
+		}
+	}
+	if len(params.MaskGenFunc.Algorithm) > 0 {
+		if !params.MaskGenFunc.Algorithm.Equal(oidMGF1) {
+			return nil, xerrors.Errorf("mask generation function %v: %w", params.MaskGenFunc.Algorithm, ErrUnsupportedAlgorithm)
+		}
+		var mgfHash pkix.AlgorithmIdentifier
+		if _, err = asn1.Unmarshal(params.MaskGenFunc.Parameters.FullBytes, &mgfHash); err != nil {
+			return nil, xerrors.Errorf("unmarshaling MGF1 parameters: %w", err)
+		}
+		if opts.MGFHash, err = getHashForOID(mgfHash.Algorithm); err != nil {
+			return nil, xerrors.Errorf("MGF1 hash: %w", err)
+		}
+	}
+	if len(params.PSourceFunc.Algorithm) > 0 {
+		if !params.PSourceFunc.Algorithm.Equal(oidPSpecified) {
+			return nil, xerrors.Errorf("OAEP label source %v: %w", params.PSourceFunc.Algorithm, ErrUnsupportedAlgorithm)
+		}
+		if _, err = asn1.Unmarshal(params.PSourceFunc.Parameters.FullBytes, &opts.Label); err != nil {
+			return nil, xerrors.Errorf("unmarshaling OAEP label: %w", err)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,146 @@
+package pkcs7
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// PEM block types used for PKCS7 and CMS messages
+const (
+	PEMTypePKCS7 = "PKCS7"
+	PEMTypeCMS   = "CMS"
+)
+
+// ErrNoPEMMessage is returned by ParsePEM when the input holds no PKCS7 or CMS
+// PEM block
+var ErrNoPEMMessage = xerrors.New("pkcs7: no PKCS7 or CMS PEM block found")
+
+// ParsePEM decodes the first PEM block labeled PKCS7 or CMS found in data and
+// parses the message it holds. Blocks of other types, such as certificates, are
+// skipped.
+func ParsePEM(data []byte) (*PKCS7, error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, ErrNoPEMMessage
+		}
+		if block.Type == PEMTypePKCS7 || block.Type == PEMTypeCMS {
+			return Parse(block.Bytes)
+		}
+	}
+}
+
+// EncodePEM wraps the DER or BER encoded message in a PEM block labeled PKCS7,
+// as written by openssl
+func EncodePEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: PEMTypePKCS7, Bytes: der})
+}
+
+// NewPEMEncoder creates stream PKCS signer like NewEncoder, but armors the
+// output as a PEM block labeled PKCS7 while streaming. SignFrom writes the PEM
+// footer once the message is complete.
+func NewPEMEncoder(w io.Writer) *SignedData {
+	return NewEncoder(newPEMWriter(w, PEMTypePKCS7))
+}
+
+// pemLineLength is the number of base64 characters per line of a PEM block
+const pemLineLength = 64
+
+// pemWriter base64 encodes everything written to it into a PEM block
+type pemWriter struct {
+	w         io.Writer
+	enc       io.WriteCloser
+	blockType string
+	started   bool
+	lines     *lineWriter
+}
+
+func newPEMWriter(w io.Writer, blockType string) *pemWriter {
+	lines := &lineWriter{w: w}
+	return &pemWriter{
+		w:         w,
+		enc:       base64.NewEncoder(base64.StdEncoding, lines),
+		blockType: blockType,
+		lines:     lines,
+	}
+}
+
+func (pw *pemWriter) header() error {
+	if pw.started {
+		return nil
+	}
+	pw.started = true
+	_, err := io.WriteString(pw.w, "-----BEGIN "+pw.blockType+"-----\n")
+	return err
+}
+
+func (pw *pemWriter) Write(p []byte) (int, error) {
+	if err := pw.header(); err != nil {
+		return 0, err
+	}
+	return pw.enc.Write(p)
+}
+
+// Close encodes the remaining bytes and writes the PEM footer
+func (pw *pemWriter) Close() (err error) {
+	if err = pw.header(); err != nil {
+		return
+	}
+	if err = pw.enc.Close(); err != nil {
+		return
+	}
+	if pw.lines.n > 0 {
+		if _, err = io.WriteString(pw.w, "\n"); err != nil {
+			return
+		}
+	}
+	_, err = io.WriteString(pw.w, "-----END "+pw.blockType+"-----\n")
+	return
+}
+
+// armoredSize returns the size of the PEM block for n bytes of data: the
+// header, the base64 text broken into lines and the footer
+func (pw *pemWriter) armoredSize(n int64) int64 {
+	text := (n + 2) / 3 * 4
+	lines := (text + pemLineLength - 1) / pemLineLength
+	return int64(len("-----BEGIN "+pw.blockType+"-----\n")) + text + lines + int64(len("-----END "+pw.blockType+"-----\n"))
+}
+
+// Flush flushes the underlying writer if it implements a Flush method
+func (pw *pemWriter) Flush() error {
+	if f, ok := pw.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// lineWriter breaks the base64 text into lines of pemLineLength characters
+type lineWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *lineWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		chunk := pemLineLength - lw.n
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		if _, err = lw.w.Write(p[:chunk]); err != nil {
+			return
+		}
+		written += chunk
+		lw.n += chunk
+		p = p[chunk:]
+		if lw.n == pemLineLength {
+			if _, err = io.WriteString(lw.w, "\n"); err != nil {
+				return
+			}
+			lw.n = 0
+		}
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package pkcs7
+
+import (
+	"bytes"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestParsePEM(t *testing.T) {
+	fixture := UnmarshalTestFixture(SignedTestFixture)
+	p7, err := ParsePEM([]byte(SignedTestFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p7.Verify(); err != nil {
+		t.Errorf("Verify failed with error: %v", err)
+	}
+
+	encoded := EncodePEM(fixture.Input)
+	if !bytes.HasPrefix(encoded, []byte("-----BEGIN PKCS7-----")) {
+		t.Errorf("unexpected PEM header in %q", encoded)
+	}
+	block, _ := pem.Decode(encoded)
+	if block == nil || !bytes.Equal(block.Bytes, fixture.Input) {
+		t.Fatal("encoded PEM does not hold the message")
+	}
+
+	cms := strings.Replace(string(encoded), PEMTypePKCS7, PEMTypeCMS, -1)
+	reparsed, err := ParsePEM([]byte(cms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reparsed.Content, p7.Content) {
+		t.Errorf("expected content %q, got %q", p7.Content, reparsed.Content)
+	}
+	if err = reparsed.Verify(); err != nil {
+		t.Errorf("Verify of CMS block failed with error: %v", err)
+	}
+
+	if _, err = ParsePEM([]byte("not a PEM block")); !xerrors.Is(err, ErrNoPEMMessage) {
+		t.Errorf("expected ErrNoPEMMessage, got %v", err)
+	}
+}
+
+func TestPEMEncoder(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("Hello World "), 100)
+	buf := new(bytes.Buffer)
+	toBeSigned := NewPEMEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	block, rest := pem.Decode(buf.Bytes())
+	if block == nil || block.Type != PEMTypePKCS7 {
+		t.Fatalf("expected PKCS7 PEM block, got %q", buf.Bytes())
+	}
+	if len(rest) != 0 {
+		t.Errorf("unexpected trailing data %q", rest)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if len(line) > pemLineLength {
+			t.Fatalf("line longer than %d characters: %q", pemLineLength, line)
+		}
+	}
+	p7, err := ParsePEM(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p7.Content, content) {
+		t.Error("content mismatch")
+	}
+	if err = p7.Verify(); err != nil {
+		t.Errorf("Verify failed with error: %v", err)
+	}
+}
@@ -3,20 +3,25 @@ package pkcs7
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/des"
+	"crypto/ecdsa"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"fmt"
 	"hash"
+	"io"
 	"math/big"
 	"sort"
+	"sync"
 	"time"
 
 	_ "crypto/sha1"   // for crypto.SHA1
@@ -35,7 +40,59 @@ type PKCS7 struct {
 	Signers                    []signerInfo
 	digestAlgorithmIdentifiers []pkix.AlgorithmIdentifier `asn1:"set"`
 	hashes                     map[crypto.Hash]hash.Hash
+	digest                     io.Writer
+	hasContent                 bool
+	version                    int
+	indefiniteLength           bool
+	der                        []byte
+	lazySigners                []asn1.RawValue
+	progress                   contentReader
+	limit                      int64
+	contentType                asn1.ObjectIdentifier
+	eContent                   []byte
+	fragments                  int
 	raw                        interface{}
+
+	// Canonicalize, when set, is called by the streaming decoder with the
+	// writer feeding the content digests and returns the writer that content
+	// is passed through before digesting. It lets callers undo transfer
+	// encodings or normalize line endings applied after signing. The content
+	// written to the destination is not affected. If the returned writer
+	// implements io.Closer, it is closed once the content has been read.
+	Canonicalize func(io.Writer) io.Writer
+
+	// OnSignerInfo, when set, is called by the streaming decoder for every
+	// signer once the signer infos are read, with the certificate of the
+	// signer if the message carries it. Returning an error aborts verification
+	// with that error. VerifyExternalTo calls it before reading anything from
+	// the external content. Signer infos follow the embedded content, so
+	// VerifyTo holds the content in memory, up to MaxHeldContent, and writes
+	// it into dest only once every signer is accepted.
+	OnSignerInfo func(index int, sid IssuerAndSerial, cert *x509.Certificate) error
+
+	// MaxHeldContent is the largest embedded content VerifyTo holds back while
+	// OnSignerInfo is set, zero means 64 MiB. Larger content fails with
+	// ErrContentTooLarge and is verified with VerifyExternalTo instead.
+	MaxHeldContent int64
+
+	// MaxContentFragments, when positive, limits the number of fragments of a
+	// constructed OCTET STRING content the streaming decoder accepts before
+	// failing with ErrTooManyFragments. The default is no limit.
+	MaxContentFragments int
+
+	// MaxContentSize, when positive, makes the streaming decoder fail with
+	// ErrContentTooLarge as soon as the content grows beyond this many bytes.
+	// Nothing past the limit is written into the destination.
+	MaxContentSize int64
+
+	// OnProgress, when set, is called by the streaming decoder with the
+	// number of content bytes read so far every time it reads more of them.
+	OnProgress func(read int64)
+
+	// MinRecipientKeyBits, when positive, makes Decrypt reject RSA key
+	// transport recipients whose key is shorter than this many bits with
+	// ErrWeakRecipientKey. The default accepts any key size.
+	MinRecipientKeyBits int
 }
 
 type contentInfo struct {
@@ -45,9 +102,20 @@ type contentInfo struct {
 
 // ErrUnsupportedContentType is returned when a PKCS7 content is not supported.
 // Currently only Data (1.2.840.113549.1.7.1), Signed Data (1.2.840.113549.1.7.2),
-// and Enveloped Data are supported (1.2.840.113549.1.7.3)
+// Enveloped Data (1.2.840.113549.1.7.3), Authenticated Data
+// (1.2.840.113549.1.9.16.1.2), Compressed Data (1.2.840.113549.1.9.16.1.9)
+// and Authenticated-Enveloped Data (1.2.840.113549.1.9.16.1.23) are supported
 var ErrUnsupportedContentType = xerrors.New("pkcs7: cannot parse data: unimplemented content type")
 
+// ErrNotSignedData is returned when a message of another content type, e.g.
+// EnvelopedData, is passed where SignedData is expected
+var ErrNotSignedData = xerrors.New("pkcs7: message is not SignedData")
+
+// ErrNotCMS is returned when the input does not start like a CMS ContentInfo,
+// i.e. a SEQUENCE holding a PKCS #7 or S/MIME content type first, as happens
+// when e.g. JSON or an image is submitted by mistake
+var ErrNotCMS = xerrors.New("pkcs7: not a CMS/PKCS7 message")
+
 type unsignedData []byte
 
 var (
@@ -77,8 +145,15 @@ type rawCertificates struct {
 
 type envelopedData struct {
 	Version              int
-	RecipientInfos       []recipientInfo `asn1:"set"`
+	OriginatorInfo       originatorInfo  `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
 	EncryptedContentInfo encryptedContentInfo
+	UnprotectedAttrs     []attribute `asn1:"optional,set,tag:1"`
+}
+
+type originatorInfo struct {
+	Certificates rawCertificates        `asn1:"optional,tag:0"`
+	CRLs         []pkix.CertificateList `asn1:"optional,tag:1"`
 }
 
 type recipientInfo struct {
@@ -104,6 +179,21 @@ type issuerAndSerial struct {
 	SerialNumber *big.Int
 }
 
+// InvalidSignatureError is returned when the content digest matches but the
+// signature value does not verify with the signer's public key
+type InvalidSignatureError struct {
+	Err error
+}
+
+func (err *InvalidSignatureError) Error() string {
+	return fmt.Sprintf("pkcs7: Signature invalid: %v", err.Err)
+}
+
+// Unwrap returns the underlying verification error
+func (err *InvalidSignatureError) Unwrap() error {
+	return err.Err
+}
+
 // MessageDigestMismatchError is returned when the signer data digest does not
 // match the computed digest for the contained content
 type MessageDigestMismatchError struct {
@@ -116,6 +206,7 @@ func (err *MessageDigestMismatchError) Error() string {
 }
 
 type signerInfo struct {
+	Raw                       asn1.RawContent
 	Version                   int `asn1:"default:1"`
 	IssuerAndSerialNumber     issuerAndSerial
 	DigestAlgorithm           pkix.AlgorithmIdentifier
@@ -125,70 +216,426 @@ type signerInfo struct {
 	UnauthenticatedAttributes []attribute `asn1:"optional,tag:1"`
 }
 
+// unknownContent holds a message of a content type Parse does not implement
+type unknownContent struct {
+	contentType asn1.ObjectIdentifier
+	content     asn1.RawValue
+}
+
+// ContentBytes returns the content type and the DER encoded content of a
+// message of an unknown content type, as ParseWithOptions keeps it with
+// ParseOptions.UnknownContentTypes
+func (p7 *PKCS7) ContentBytes() (asn1.ObjectIdentifier, []byte, error) {
+	raw, ok := p7.raw.(unknownContent)
+	if !ok {
+		return nil, nil, xerrors.New("pkcs7: content type is known, use the accessors of its message type")
+	}
+	return raw.contentType, raw.content.FullBytes, nil
+}
+
+// ParseOptions are the optional settings of ParseWithOptions. The zero value
+// parses like Parse.
+type ParseOptions struct {
+	// UnknownContentTypes makes the parser return messages of content types
+	// it does not implement instead of failing with ErrUnsupportedContentType,
+	// so that gateways can inspect or pass through novel CMS types.
+	// ContentBytes exposes their content undecoded and WriteTo re-encodes them
+	// as they were. The content type check of ErrNotCMS is relaxed to any OID.
+	UnknownContentTypes bool
+	// StrictDER rejects input that uses BER indefinite-length encoding
+	// instead of transcoding it to DER
+	StrictDER bool
+	// LenientLengths accepts BER lengths encoded with leading zero octets, as
+	// some legacy producers emit them. The transcoded output is still
+	// canonical DER. StrictDER rejects them regardless.
+	LenientLengths bool
+}
+
 // Parse decodes a BER encoded PKCS7 package
 func Parse(data []byte) (p7 *PKCS7, err error) {
+	return ParseWithOptions(data, ParseOptions{})
+}
+
+// ParseWithOptions is like Parse with the settings of opts, which apply to
+// this call only
+func ParseWithOptions(data []byte, opts ParseOptions) (p7 *PKCS7, err error) {
 	if len(data) == 0 {
 		return nil, xerrors.New("pkcs7: input data is empty")
 	}
-	var info contentInfo
-	der, err := ber2der(data)
+	info, der, indefinite, err := opts.decodeContentInfo(data)
 	if err != nil {
 		return nil, err
 	}
-	rest, err := asn1.Unmarshal(der, &info)
-	if len(rest) > 0 {
-		err = asn1.SyntaxError{Msg: "trailing data"}
-		return
+
+	// fmt.Printf("--> Content Type: %s", info.ContentType)
+	switch {
+	case info.ContentType.Equal(oidSignedData):
+		p7, err = parseSignedData(info.Content.Bytes)
+	case info.ContentType.Equal(oidEnvelopedData):
+		p7, err = parseEnvelopedData(info.Content.Bytes)
+	case info.ContentType.Equal(oidAuthenticatedData):
+		p7, err = parseAuthenticatedData(info.Content.Bytes)
+	case info.ContentType.Equal(oidAuthEnvelopedData):
+		p7, err = parseAuthEnvelopedData(info.Content.Bytes)
+	case info.ContentType.Equal(oidCompressedData):
+		p7, err = parseCompressedData(info.Content.Bytes)
+	case opts.UnknownContentTypes:
+		p7 = &PKCS7{raw: unknownContent{
+			contentType: info.ContentType,
+			content:     asn1.RawValue{FullBytes: info.Content.Bytes},
+		}}
+	default:
+		return nil, ErrUnsupportedContentType
 	}
 	if err != nil {
+		return nil, err
+	}
+	p7.indefiniteLength = indefinite
+	p7.der = der
+	p7.contentType = info.ContentType
+	return p7, nil
+}
+
+// ContentType returns the content type of the outer ContentInfo of the
+// message, e.g. id-signedData, once it is parsed or decoded
+func (p7 *PKCS7) ContentType() asn1.ObjectIdentifier {
+	return p7.contentType
+}
+
+// checkSignedDataType fails with ErrNotSignedData unless contentType is
+// id-signedData
+func checkSignedDataType(contentType asn1.ObjectIdentifier) error {
+	if !contentType.Equal(oidSignedData) {
+		return xerrors.Errorf("pkcs7: content type %v: %w", contentType, ErrNotSignedData)
+	}
+	return nil
+}
+
+// signedData returns the parsed SignedData of the message, failing with
+// ErrNotSignedData for other content types
+func (p7 *PKCS7) signedData() (signedData, error) {
+	sd, ok := p7.raw.(signedData)
+	if !ok {
+		if err := checkSignedDataType(p7.contentType); err != nil {
+			return signedData{}, err
+		}
+		return signedData{}, xerrors.New("pkcs7: SignedData was not parsed")
+	}
+	return sd, nil
+}
+
+// decodeContentInfo transcodes data to DER if needed and unmarshals the outer
+// ContentInfo, returning the DER and reporting whether data used
+// indefinite-length encoding
+func (opts ParseOptions) decodeContentInfo(data []byte) (info contentInfo, der []byte, indefinite bool, err error) {
+	if err = opts.checkContentInfoHeader(data); err != nil {
 		return
 	}
+	der = data
+	if !isDER(data) {
+		indefinite = hasIndefiniteLength(data)
+		if der, err = opts.ber2der(data); err != nil {
+			return
+		}
+	}
+	rest, err := asn1.Unmarshal(der, &info)
+	if err == nil && len(rest) > 0 {
+		err = asn1.SyntaxError{Msg: "trailing data"}
+	}
+	return
+}
 
-	// fmt.Printf("--> Content Type: %s", info.ContentType)
+var (
+	oidPKCS7ContentTypes = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7}
+	oidCMSContentTypes   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1}
+)
+
+// checkContentInfoHeader looks at the outer tag and the content type at the
+// start of data and returns ErrNotCMS unless they can begin a CMS message, so
+// that obviously wrong input is rejected before it is transcoded or parsed.
+// Content types under the PKCS #7 and S/MIME arcs pass, or any content type
+// with UnknownContentTypes, unsupported ones are left to the parser.
+func (opts ParseOptions) checkContentInfoHeader(data []byte) error {
+	if len(data) < 2 || data[0] != 0x30 {
+		return ErrNotCMS
+	}
+	offset := 2
+	if l := data[1]; l > 0x80 {
+		offset += int(l & 0x7F)
+	}
+	if offset >= len(data) || data[offset] != asn1.TagOID {
+		return ErrNotCMS
+	}
+	var contentType asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(data[offset:], &contentType); err != nil {
+		return ErrNotCMS
+	}
+	if !opts.UnknownContentTypes && !hasOIDPrefix(contentType, oidPKCS7ContentTypes) && !hasOIDPrefix(contentType, oidCMSContentTypes) {
+		return ErrNotCMS
+	}
+	return nil
+}
+
+// hasOIDPrefix reports whether oid lies directly under the arc prefix
+func hasOIDPrefix(oid, prefix asn1.ObjectIdentifier) bool {
+	return len(oid) == len(prefix)+1 && prefix.Equal(oid[:len(prefix)])
+}
+
+// ValidateStructure checks that data is a well-formed CMS message of one of
+// the supported content types without parsing certificates, decrypting or
+// verifying anything, so that malformed input can be rejected cheaply before
+// the expensive work.
+func ValidateStructure(data []byte) error {
+	if len(data) == 0 {
+		return xerrors.New("pkcs7: input data is empty")
+	}
+	info, _, _, err := ParseOptions{}.decodeContentInfo(data)
+	if err != nil {
+		return xerrors.Errorf("pkcs7: malformed ContentInfo: %w", err)
+	}
+	// Parse ignores what follows the message, the validation does not
+	if _, end, err := readObject(data, 0); err != nil || end < len(data) {
+		return xerrors.New("pkcs7: malformed ContentInfo: trailing data")
+	}
+	var content interface{}
 	switch {
 	case info.ContentType.Equal(oidSignedData):
-		return parseSignedData(info.Content.Bytes)
+		content = &signedData{}
 	case info.ContentType.Equal(oidEnvelopedData):
-		return parseEnvelopedData(info.Content.Bytes)
+		content = &envelopedData{}
+	case info.ContentType.Equal(oidAuthenticatedData):
+		content = &authenticatedData{}
+	case info.ContentType.Equal(oidAuthEnvelopedData):
+		content = &authEnvelopedData{}
+	case info.ContentType.Equal(oidCompressedData):
+		content = &compressedData{}
+	default:
+		return ErrUnsupportedContentType
+	}
+	rest, err := asn1.Unmarshal(info.Content.Bytes, content)
+	if err == nil && len(rest) > 0 {
+		err = asn1.SyntaxError{Msg: "trailing data"}
+	}
+	if err != nil {
+		return xerrors.Errorf("pkcs7: malformed %v content: %w", info.ContentType, err)
+	}
+	if sd, ok := content.(*signedData); ok {
+		if _, err = sd.ContentInfo.unwrap(); err != nil {
+			return xerrors.Errorf("pkcs7: malformed encapsulated content: %w", err)
+		}
+		if len(sd.Certificates.Raw) > 0 {
+			if _, err = rawFields(sd.Certificates.Raw); err != nil {
+				return xerrors.Errorf("pkcs7: malformed certificates: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseMultiple decodes a sequence of concatenated BER encoded PKCS7 packages,
+// as found in batch formats, and returns them in order
+func ParseMultiple(data []byte) ([]*PKCS7, error) {
+	if len(data) == 0 {
+		return nil, xerrors.New("pkcs7: input data is empty")
+	}
+	var res []*PKCS7
+	for offset := 0; offset < len(data); {
+		_, end, err := readObject(data, offset)
+		if err != nil {
+			return nil, xerrors.Errorf("pkcs7: message %d: %w", len(res), err)
+		}
+		p7, err := Parse(data[offset:end])
+		if err != nil {
+			return nil, xerrors.Errorf("pkcs7: message %d: %w", len(res), err)
+		}
+		res = append(res, p7)
+		offset = end
+	}
+	return res, nil
+}
+
+// WasIndefiniteLength reports whether the parsed input used BER
+// indefinite-length encoding anywhere, i.e. whether it was not DER.
+func (p7 *PKCS7) WasIndefiniteLength() bool {
+	if p7.r != nil && p7.r.indefinite {
+		return true
+	}
+	return p7.indefiniteLength
+}
+
+// DER returns the DER encoding of the message Parse decoded, with fragmented
+// OCTET STRINGs joined and SET elements sorted, so that storing it instead of
+// the input normalizes BER encoded signatures. After AddCertificates,
+// AddUnsignedAttribute or RemoveUnsignedAttributes it encodes the changed
+// message. It is nil for messages read by the streaming decoder and for
+// messages that cannot be encoded.
+func (p7 *PKCS7) DER() []byte {
+	der := p7.der
+	if der == nil {
+		if p7.raw == nil {
+			return nil
+		}
+		var buf bytes.Buffer
+		if _, err := p7.WriteTo(&buf); err != nil {
+			return nil
+		}
+		der = buf.Bytes()
+	}
+	canonical, err := canonicalDER(der)
+	if err != nil {
+		return nil
+	}
+	return canonical
+}
+
+// MessageType tells which kind of CMS structure a parsed message holds
+type MessageType int
+
+// Message types returned by PKCS7.Type
+const (
+	MessageTypeUnknown MessageType = iota
+	MessageTypeSignedData
+	// MessageTypeCertsOnly is a SignedData without signers carrying only
+	// certificates and CRLs
+	MessageTypeCertsOnly
+	MessageTypeEnvelopedData
+	MessageTypeAuthenticatedData
+	MessageTypeCompressedData
+	MessageTypeAuthEnvelopedData
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MessageTypeSignedData:
+		return "SignedData"
+	case MessageTypeCertsOnly:
+		return "CertsOnly"
+	case MessageTypeEnvelopedData:
+		return "EnvelopedData"
+	case MessageTypeAuthenticatedData:
+		return "AuthenticatedData"
+	case MessageTypeCompressedData:
+		return "CompressedData"
+	case MessageTypeAuthEnvelopedData:
+		return "AuthEnvelopedData"
+	}
+	return "Unknown"
+}
+
+// Type returns the kind of the parsed message
+func (p7 *PKCS7) Type() MessageType {
+	switch p7.raw.(type) {
+	case signedData:
+		if p7.SignerCount() == 0 {
+			return MessageTypeCertsOnly
+		}
+		return MessageTypeSignedData
+	case envelopedData:
+		return MessageTypeEnvelopedData
+	case authenticatedData:
+		return MessageTypeAuthenticatedData
+	case compressedData:
+		return MessageTypeCompressedData
+	case authEnvelopedData:
+		return MessageTypeAuthEnvelopedData
+	}
+	return MessageTypeUnknown
+}
+
+// ParseTyped decodes a BER encoded PKCS7 package and reports which kind of
+// message it is, so that callers know which accessors apply
+func ParseTyped(data []byte) (MessageType, *PKCS7, error) {
+	p7, err := Parse(data)
+	if err != nil {
+		return MessageTypeUnknown, nil, err
 	}
-	return nil, ErrUnsupportedContentType
+	return p7.Type(), p7, nil
 }
 
 func parseSignedData(data []byte) (*PKCS7, error) {
 	var sd signedData
-	asn1.Unmarshal(data, &sd)
+	if _, err := asn1.Unmarshal(data, &sd); err != nil {
+		return nil, err
+	}
+	return newSignedPKCS7(sd)
+}
+
+// newSignedPKCS7 builds the parsed message from the decoded SignedData
+func newSignedPKCS7(sd signedData) (*PKCS7, error) {
 	certs, err := sd.Certificates.Parse()
 	if err != nil {
 		return nil, err
 	}
 	// fmt.Printf("--> Signed Data Version %d\n", sd.Version)
 
-	var compound asn1.RawValue
-	var content unsignedData
-
-	// The Content.Bytes maybe empty on PKI responses.
-	if len(sd.ContentInfo.Content.Bytes) > 0 {
-		if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &compound); err != nil {
+	content, err := sd.ContentInfo.unwrap()
+	if err != nil {
+		return nil, err
+	}
+	var eContent []byte
+	if sd.ContentInfo.ContentType.Equal(oidCompressedData) && content != nil {
+		// compressed content is exposed decompressed, but digested as is
+		var cd compressedData
+		if _, err = asn1.Unmarshal(content, &cd); err != nil {
 			return nil, err
 		}
-	}
-	// Compound octet string
-	if compound.IsCompound {
-		if _, err = asn1.Unmarshal(compound.Bytes, &content); err != nil {
+		eContent = content
+		if content, err = cd.decompress(); err != nil {
 			return nil, err
 		}
-	} else {
-		// assuming this is tag 04
-		content = compound.Bytes
 	}
 	return &PKCS7{
 		Content:      content,
 		Certificates: certs,
 		CRLs:         sd.CRLs,
 		Signers:      sd.SignerInfos,
+		eContent:     eContent,
+		version:      sd.Version,
 		raw:          sd}, nil
 }
 
+// signedContent returns the content octets covered by the message digest,
+// which differ from Content when the signed content is compressed
+func (p7 *PKCS7) signedContent() []byte {
+	if p7.eContent != nil {
+		return p7.eContent
+	}
+	return p7.Content
+}
+
+// unwrap extracts the content octets of the encapsulated content info. For an
+// OCTET STRING, which may be split into fragments, the octets it holds are
+// returned; any other content type, as allowed by PKCS #7 v1.5, yields the
+// contents octets of its encoding.
+func (ci contentInfo) unwrap() (unsignedData, error) {
+	var compound asn1.RawValue
+
+	// The Content.Bytes maybe empty on PKI responses.
+	if len(ci.Content.Bytes) == 0 {
+		return nil, nil
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &compound); err != nil {
+		return nil, err
+	}
+	if compound.Class != asn1.ClassUniversal || compound.Tag != asn1.TagOctetString || !compound.IsCompound {
+		return compound.Bytes, nil
+	}
+	// Compound octet string
+	var content unsignedData
+	for rest := compound.Bytes; len(rest) > 0; {
+		var part asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &part); err != nil {
+			return nil, err
+		}
+		if part.Class != asn1.ClassUniversal || part.Tag != asn1.TagOctetString {
+			return nil, asn1.StructuralError{Msg: "constructed OCTET STRING holds a non OCTET STRING fragment"}
+		}
+		content = append(content, part.Bytes...)
+	}
+	return content, nil
+}
+
 func (raw rawCertificates) Parse() ([]*x509.Certificate, error) {
 	if len(raw.Raw) == 0 {
 		return nil, nil
@@ -209,63 +656,185 @@ func (raw rawCertificates) Parse() ([]*x509.Certificate, error) {
 	return res, nil
 }
 
+// parseEnvelopedData decodes an EnvelopedData, exposing the certificates and
+// CRLs of its originatorInfo as Certificates and CRLs
 func parseEnvelopedData(data []byte) (*PKCS7, error) {
 	var ed envelopedData
 	if _, err := asn1.Unmarshal(data, &ed); err != nil {
 		return nil, err
 	}
+	certs, err := ed.OriginatorInfo.Certificates.Parse()
+	if err != nil {
+		return nil, err
+	}
 	return &PKCS7{
-		raw: ed,
+		Certificates: certs,
+		CRLs:         ed.OriginatorInfo.CRLs,
+		version:      ed.Version,
+		raw:          ed,
 	}, nil
 }
 
+// Version returns the version field of the parsed SignedData, EnvelopedData or
+// AuthenticatedData, e.g. 1 for plain signed data or 3 when signers are
+// identified by subject key identifier or the content is not id-data.
+func (p7 *PKCS7) Version() int {
+	return p7.version
+}
+
+// EnvelopedVersion returns the version of a parsed EnvelopedData: 0 when only
+// key transport recipients identified by issuer and serial are present, 2
+// when originatorInfo, unprotected attributes or key agreement recipients are.
+// It returns -1 for other content types.
+func (p7 *PKCS7) EnvelopedVersion() int {
+	data, ok := p7.raw.(envelopedData)
+	if !ok {
+		return -1
+	}
+	return data.Version
+}
+
+// RecipientCount returns the number of recipient infos of a parsed
+// EnvelopedData or AuthEnvelopedData, which is 0 for other content types. Key
+// agreement recipients count once however many recipient keys they hold.
+func (p7 *PKCS7) RecipientCount() int {
+	recipientInfos, _ := p7.recipientInfos()
+	return len(recipientInfos)
+}
+
+// recipientInfos returns the recipient infos of a parsed EnvelopedData or
+// AuthEnvelopedData
+func (p7 *PKCS7) recipientInfos() ([]asn1.RawValue, bool) {
+	switch data := p7.raw.(type) {
+	case envelopedData:
+		return data.RecipientInfos, true
+	case authEnvelopedData:
+		return data.RecipientInfos, true
+	}
+	return nil, false
+}
+
 // Verify checks the signatures of a PKCS7 object
 // WARNING: Verify does not check signing time or verify certificate chains at
 // this time.
 func (p7 *PKCS7) Verify() (err error) {
+	_, err = p7.verify()
+	return err
+}
+
+// verify checks the signatures of all signers and returns the certificates
+// that verified them, in the order of the signers
+func (p7 *PKCS7) verify() (certs []*x509.Certificate, err error) {
+	if p7.contentType != nil {
+		if err = checkSignedDataType(p7.contentType); err != nil {
+			return nil, err
+		}
+	}
+	if err = p7.loadSigners(); err != nil {
+		return nil, err
+	}
 	if len(p7.Signers) == 0 {
-		return xerrors.New("pkcs7: Message has no signers")
+		return nil, xerrors.New("pkcs7: Message has no signers")
+	}
+	if sd, ok := p7.raw.(signedData); ok {
+		if err := checkDigestAlgorithms(sd.DigestAlgorithmIdentifiers, p7.Signers); err != nil {
+			return nil, err
+		}
 	}
+	certs = make([]*x509.Certificate, 0, len(p7.Signers))
 	for _, signer := range p7.Signers {
-		if err := verifySignature(p7, signer); err != nil {
+		cert, err := verifySignature(p7, signer)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// VerifySigner checks the signature of the signer at index only, so that
+// signers of a multi-signer message can be validated independently, e.g.
+// against different trust requirements
+func (p7 *PKCS7) VerifySigner(index int) error {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return err
+	}
+	if sd, ok := p7.raw.(signedData); ok {
+		if err = checkDigestAlgorithm(sd.DigestAlgorithmIdentifiers, index, *signer); err != nil {
+			return err
+		}
+	}
+	_, err = verifySignature(p7, *signer)
+	return err
+}
+
+// ErrDigestAlgorithmMismatch is returned when the digest algorithm of a signer
+// is missing from the digestAlgorithms of the SignedData
+var ErrDigestAlgorithmMismatch = xerrors.New("pkcs7: signer digest algorithm not listed in digestAlgorithms")
+
+// checkDigestAlgorithms makes sure that every signer uses one of the digest
+// algorithms announced for the message, as RFC 5652 requires
+func checkDigestAlgorithms(digestAlgorithms []pkix.AlgorithmIdentifier, signers []signerInfo) error {
+	for i, signer := range signers {
+		if err := checkDigestAlgorithm(digestAlgorithms, i, signer); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func verifySignature(p7 *PKCS7, signer signerInfo) error {
-	signedData := p7.Content
+func checkDigestAlgorithm(digestAlgorithms []pkix.AlgorithmIdentifier, index int, signer signerInfo) error {
+	for _, aid := range digestAlgorithms {
+		if aid.Algorithm.Equal(signer.DigestAlgorithm.Algorithm) {
+			return nil
+		}
+	}
+	return xerrors.Errorf("signer %d digest %v: %w", index, signer.DigestAlgorithm.Algorithm, ErrDigestAlgorithmMismatch)
+}
+
+func verifySignature(p7 *PKCS7, signer signerInfo) (*x509.Certificate, error) {
 	hash, err := getHashForOID(signer.DigestAlgorithm.Algorithm)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(p7.signedContent())
+	return verifySignerInfo(signer, p7.Certificates, h.Sum(nil))
+}
+
+// verifySignerInfo checks the signer against the digest of the content
+// computed with the signer's digest algorithm and returns the certificate whose
+// key verified the signature
+func verifySignerInfo(signer signerInfo, certs []*x509.Certificate, computed []byte) (*x509.Certificate, error) {
+	hash, err := getHashForOID(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
 	}
+	var signedData []byte
 	if len(signer.AuthenticatedAttributes) > 0 {
 		// TODO(fullsailor): First check the content type match
 		var digest []byte
 		err := unmarshalAttribute(signer.AuthenticatedAttributes, oidAttributeMessageDigest, &digest)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		h := hash.New()
-		h.Write(p7.Content)
-		computed := h.Sum(nil)
 		if !hmac.Equal(digest, computed) {
-			return &MessageDigestMismatchError{
+			return nil, &MessageDigestMismatchError{
 				ExpectedDigest: digest,
 				ActualDigest:   computed,
 			}
 		}
 		// TODO(fullsailor): Optionally verify certificate chain
 		// TODO(fullsailor): Optionally verify signingTime against certificate NotAfter/NotBefore
-		signedData, err = marshalAttributes(signer.AuthenticatedAttributes)
+		signedData, err = signer.signedAttributesBytes()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	cert := getCertFromCertsByIssuerAndSerial(p7.Certificates, signer.IssuerAndSerialNumber)
-	if cert == nil {
-		return xerrors.New("pkcs7: No certificate for signer")
+	candidates := getCertsFromCertsByIssuerAndSerial(certs, signer.IssuerAndSerialNumber)
+	if len(candidates) == 0 {
+		return nil, xerrors.New("pkcs7: No certificate for signer")
 	}
 
 	algo := getSignatureAlgorithmFromAI(signer.DigestEncryptionAlgorithm)
@@ -280,7 +849,89 @@ func verifySignature(p7 *PKCS7, signer signerInfo) error {
 			algo = getRSASignatureAlgorithmForDigestAlgorithm(hash)
 		}
 	}
-	return cert.CheckSignature(algo, signedData, signer.EncryptedDigest)
+	// a misconfigured CA may have issued several certificates with the same
+	// issuer and serial, the signature has to match only one of them
+	var firstErr error
+	for _, cert := range candidates {
+		if signedData != nil {
+			err = cert.CheckSignature(algo, signedData, signer.EncryptedDigest)
+		} else {
+			err = checkDigestSignature(cert, algo, hash, computed, signer.EncryptedDigest)
+		}
+		if err == nil {
+			return cert, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if xerrors.Is(firstErr, ErrUnsupportedAlgorithm) {
+		return nil, firstErr
+	}
+	return nil, &InvalidSignatureError{Err: firstErr}
+}
+
+// checkDigestSignature verifies a signature made directly over the content
+// digest, as is the case when no authenticated attributes are present
+func checkDigestSignature(cert *x509.Certificate, algo x509.SignatureAlgorithm, hash crypto.Hash, digest, signature []byte) error {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if isRSAPSS(algo) {
+			return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+			return xerrors.Errorf("unmarshaling ECDSA signature: %w", err)
+		}
+		if sig.R == nil || sig.S == nil || !ecdsa.Verify(pub, digest, sig.R, sig.S) {
+			return xerrors.New("ECDSA verification failure")
+		}
+		return nil
+	}
+	return xerrors.Errorf("verifying %v signature: %w", algo, ErrUnsupportedAlgorithm)
+}
+
+// signedAttributesBytes returns the encoding the signature over the
+// authenticated attributes is computed on. It is the [0] IMPLICIT field of the
+// parsed signer info with its tag replaced by the SET tag, keeping the length
+// and the attributes exactly as the signer encoded them, which may differ from
+// the DER encoding of the parsed attributes.
+func (si signerInfo) signedAttributesBytes() ([]byte, error) {
+	if len(si.Raw) == 0 {
+		return marshalAttributes(si.AuthenticatedAttributes)
+	}
+	fields, err := rawFields(si.Raw)
+	if err != nil {
+		return nil, xerrors.Errorf("unmarshaling signer info: %w", err)
+	}
+	for _, field := range fields {
+		if field.Class == asn1.ClassContextSpecific && field.Tag == 0 && field.IsCompound {
+			attrs := append([]byte(nil), field.FullBytes...)
+			attrs[0] = 0x31 // SET
+			return attrs, nil
+		}
+	}
+	return nil, xerrors.New("signer info has no authenticated attributes")
+}
+
+// rawFields splits the DER encoding of a SEQUENCE into its elements
+func rawFields(der []byte) ([]asn1.RawValue, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &seq); err != nil {
+		return nil, err
+	}
+	var fields []asn1.RawValue
+	for rest := seq.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
 }
 
 func marshalAttributes(attrs []attribute) ([]byte, error) {
@@ -311,7 +962,49 @@ func getCertFromCertsByIssuerAndSerial(certs []*x509.Certificate, ias issuerAndS
 	return nil
 }
 
+func getCertsFromCertsByIssuerAndSerial(certs []*x509.Certificate, ias issuerAndSerial) []*x509.Certificate {
+	var matching []*x509.Certificate
+	for _, cert := range certs {
+		if isCertMatchForIssuerAndSerial(cert, ias) {
+			matching = append(matching, cert)
+		}
+	}
+	return matching
+}
+
+type registeredHash struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}
+
+var hashOIDs = struct {
+	sync.RWMutex
+	m map[string]registeredHash
+	// order lists the keys of m in the order they were first registered
+	order []string
+}{m: make(map[string]registeredHash)}
+
+// RegisterHashOID makes the digest algorithm identified by oid known to the
+// package, so that messages using it can be verified. It is safe to call
+// concurrently with parsing and verification. When several OIDs are
+// registered for the same hash, HashToOID returns the one registered first.
+func RegisterHashOID(oid asn1.ObjectIdentifier, hash crypto.Hash) {
+	hashOIDs.Lock()
+	defer hashOIDs.Unlock()
+	key := oid.String()
+	if _, ok := hashOIDs.m[key]; !ok {
+		hashOIDs.order = append(hashOIDs.order, key)
+	}
+	hashOIDs.m[key] = registeredHash{oid: oid, hash: hash}
+}
+
 func getHashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	hashOIDs.RLock()
+	registered, ok := hashOIDs.m[oid.String()]
+	hashOIDs.RUnlock()
+	if ok {
+		return registered.hash, nil
+	}
 	switch {
 	case oid.Equal(oidSHA1):
 		return crypto.SHA1, nil
@@ -325,24 +1018,73 @@ func getHashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
 	return crypto.Hash(0), xerrors.Errorf("getting hash for OID: %w", ErrUnsupportedAlgorithm)
 }
 
-func getSignAlgorithm(oid asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
-	switch {
-	case oid.Equal(oidSHA1):
-		return x509.SHA1WithRSA, nil
-	case oid.Equal(oidSHA256):
-		return x509.SHA256WithRSA, nil
-	case oid.Equal(oidSHA384):
-		return x509.SHA384WithRSA, nil
-	case oid.Equal(oidSHA512):
-		return x509.SHA512WithRSA, nil
-	}
-	return x509.UnknownSignatureAlgorithm, ErrUnsupportedAlgorithm
+// OIDToHash returns the hash function identified by the digest algorithm
+// OID, including those added with RegisterHashOID
+func OIDToHash(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	hash, err := getHashForOID(oid)
+	return hash, err == nil
 }
 
-func getRSASignatureAlgorithmForDigestAlgorithm(hash crypto.Hash) x509.SignatureAlgorithm {
-	for _, details := range signatureAlgorithmDetails {
-		if details.pubKeyAlgo == x509.RSA && details.hash == hash {
-			return details.algo
+// HashToOID returns the digest algorithm OID of the hash function, including
+// those added with RegisterHashOID
+func HashToOID(hash crypto.Hash) (asn1.ObjectIdentifier, bool) {
+	switch hash {
+	case crypto.SHA1:
+		return oidSHA1, true
+	case crypto.SHA256:
+		return oidSHA256, true
+	case crypto.SHA384:
+		return oidSHA384, true
+	case crypto.SHA512:
+		return oidSHA512, true
+	}
+	hashOIDs.RLock()
+	defer hashOIDs.RUnlock()
+	for _, key := range hashOIDs.order {
+		if registered := hashOIDs.m[key]; registered.hash == hash {
+			return registered.oid, true
+		}
+	}
+	return nil, false
+}
+
+// SupportedDigestAlgorithms returns the OIDs of the digest algorithms the
+// package can verify and sign with, followed by those added with
+// RegisterHashOID
+func SupportedDigestAlgorithms() []asn1.ObjectIdentifier {
+	oids := []asn1.ObjectIdentifier{oidSHA1, oidSHA256, oidSHA384, oidSHA512}
+	hashOIDs.RLock()
+	registered := make([]asn1.ObjectIdentifier, 0, len(hashOIDs.m))
+	for _, r := range hashOIDs.m {
+		registered = append(registered, r.oid)
+	}
+	hashOIDs.RUnlock()
+	sort.Slice(registered, func(i, j int) bool { return registered[i].String() < registered[j].String() })
+	return append(oids, registered...)
+}
+
+// SupportedSignatureAlgorithms returns the OIDs of the signature algorithms
+// the package can verify, as found in the digestEncryptionAlgorithm of a
+// signer info
+func SupportedSignatureAlgorithms() []asn1.ObjectIdentifier {
+	return []asn1.ObjectIdentifier{
+		oidRSA,
+		oidSignatureSHA1WithRSA,
+		oidSignatureSHA256WithRSA,
+		oidSignatureSHA384WithRSA,
+		oidSignatureSHA512WithRSA,
+		oidSignatureRSAPSS,
+		oidSignatureECDSAWithSHA1,
+		oidSignatureECDSAWithSHA256,
+		oidSignatureECDSAWithSHA384,
+		oidSignatureECDSAWithSHA512,
+	}
+}
+
+func getRSASignatureAlgorithmForDigestAlgorithm(hash crypto.Hash) x509.SignatureAlgorithm {
+	for _, details := range signatureAlgorithmDetails {
+		if details.pubKeyAlgo == x509.RSA && details.hash == hash {
+			return details.algo
 		}
 	}
 	return x509.UnknownSignatureAlgorithm
@@ -351,39 +1093,263 @@ func getRSASignatureAlgorithmForDigestAlgorithm(hash crypto.Hash) x509.Signature
 // GetOnlySigner returns an x509.Certificate for the first signer of the signed
 // data payload. If there are more or less than one signer, nil is returned
 func (p7 *PKCS7) GetOnlySigner() *x509.Certificate {
-	if len(p7.Signers) != 1 {
+	if p7.loadSigners() != nil || len(p7.Signers) != 1 {
 		return nil
 	}
 	signer := p7.Signers[0]
 	return getCertFromCertsByIssuerAndSerial(p7.Certificates, signer.IssuerAndSerialNumber)
 }
 
+// VerifyAndContent verifies the signatures like Verify and only then returns
+// the content together with the certificate that verified the first signer, so
+// that the content cannot be used before it is verified. On failure the content
+// and the certificate are nil.
+func (p7 *PKCS7) VerifyAndContent() ([]byte, *x509.Certificate, error) {
+	certs, err := p7.verify()
+	if err != nil {
+		return nil, nil, err
+	}
+	return p7.Content, certs[0], nil
+}
+
+// CertPool returns a pool holding every certificate embedded in the message,
+// suitable as the Intermediates of x509.VerifyOptions when verifying the
+// signer's chain
+func (p7 *PKCS7) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range p7.Certificates {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// SignerCertificateFingerprint returns the hash h of the raw DER of the
+// certificate of the only signer, as displayed or pinned by user interfaces.
+// Call it after verification to fingerprint the certificate that was checked.
+func (p7 *PKCS7) SignerCertificateFingerprint(h crypto.Hash) ([]byte, error) {
+	if !h.Available() {
+		return nil, xerrors.Errorf("pkcs7: fingerprint hash %v: %w", h, ErrUnsupportedAlgorithm)
+	}
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return nil, xerrors.New("pkcs7: no certificate for the only signer")
+	}
+	hasher := h.New()
+	hasher.Write(cert.Raw)
+	return hasher.Sum(nil), nil
+}
+
 // ErrUnsupportedAlgorithm tells you when our quick dev assumptions have failed
-var ErrUnsupportedAlgorithm = xerrors.New("pkcs7: cannot decrypt data: only RSA, DES, DES-EDE3, AES-256-CBC and AES-128-GCM supported")
+var ErrUnsupportedAlgorithm = xerrors.New("pkcs7: cannot decrypt data: only RSA, ECDH, DES, DES-EDE3, AES-128-CBC, AES-256-CBC and AES-128-GCM supported")
 
 // ErrNotEncryptedContent is returned when attempting to Decrypt data that is not encrypted data
 var ErrNotEncryptedContent = xerrors.New("pkcs7: content data is a decryptable data type")
 
 // Decrypt decrypts encrypted content info for recipient cert and private key
 func (p7 *PKCS7) Decrypt(cert *x509.Certificate, pk crypto.PrivateKey) ([]byte, error) {
-	data, ok := p7.raw.(envelopedData)
+	content, _, err := p7.DecryptWithRecipient(cert, pk)
+	return content, err
+}
+
+// DecryptContext is like Decrypt for a key transport recipient whose RSA key
+// is a crypto.Decrypter, e.g. backed by a remote HSM. It returns ctx.Err()
+// as soon as ctx is done, even if the decrypter does not return, in which
+// case its result is discarded once it does.
+func (p7 *PKCS7) DecryptContext(ctx context.Context, cert *x509.Certificate, decrypter crypto.Decrypter) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p7.Decrypt(cert, contextDecrypter{ctx: ctx, Decrypter: decrypter})
+}
+
+// contextDecrypter abandons the wrapped decryption when ctx is done
+type contextDecrypter struct {
+	crypto.Decrypter
+	ctx context.Context
+}
+
+func (d contextDecrypter) Decrypt(random io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	type result struct {
+		plaintext []byte
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		plaintext, err := d.Decrypter.Decrypt(random, msg, opts)
+		done <- result{plaintext, err}
+	}()
+	select {
+	case res := <-done:
+		return res.plaintext, res.err
+	case <-d.ctx.Done():
+		return nil, xerrors.Errorf("pkcs7: unwrapping content key: %w", d.ctx.Err())
+	}
+}
+
+// IssuerAndSerial identifies a certificate by its issuer name and serial number
+type IssuerAndSerial struct {
+	// RawIssuer is the DER encoded issuer pkix.RDNSequence
+	RawIssuer    []byte
+	SerialNumber *big.Int
+}
+
+// Matches reports whether cert is the certificate identified. The issuer is
+// compared with the bytes found in the certificate, since re-encoding a name
+// can change its DER for certificates produced by other libraries.
+func (id IssuerAndSerial) Matches(cert *x509.Certificate) bool {
+	return id.SerialNumber != nil && cert.SerialNumber.Cmp(id.SerialNumber) == 0 && bytes.Equal(cert.RawIssuer, id.RawIssuer)
+}
+
+// String renders the issuer name and the hexadecimal serial number for
+// logging
+func (id IssuerAndSerial) String() string {
+	var rdns pkix.RDNSequence
+	issuer := fmt.Sprintf("%X", id.RawIssuer)
+	if rest, err := asn1.Unmarshal(id.RawIssuer, &rdns); err == nil && len(rest) == 0 {
+		issuer = rdns.String()
+	}
+	return fmt.Sprintf("%s, serial %x", issuer, id.SerialNumber)
+}
+
+func (ias issuerAndSerial) exported() IssuerAndSerial {
+	return IssuerAndSerial{
+		RawIssuer:    ias.IssuerName.FullBytes,
+		SerialNumber: ias.SerialNumber,
+	}
+}
+
+// SignerID returns the issuer and serial number identifying the certificate
+// of the signer at index
+func (p7 *PKCS7) SignerID(index int) (IssuerAndSerial, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return IssuerAndSerial{}, err
+	}
+	return signer.IssuerAndSerialNumber.exported(), nil
+}
+
+// Recipients returns the identifiers of the recipients of a parsed
+// EnvelopedData or AuthEnvelopedData, one per key transport recipient and one
+// per recipient key of the key agreement recipients
+func (p7 *PKCS7) Recipients() ([]IssuerAndSerial, error) {
+	recipientInfos, ok := p7.recipientInfos()
 	if !ok {
 		return nil, ErrNotEncryptedContent
 	}
-	recipient := selectRecipientForCertificate(data.RecipientInfos, cert)
-	if recipient.EncryptedKey == nil {
-		return nil, xerrors.New("pkcs7: no enveloped recipient for provided certificate")
+	var res []IssuerAndSerial
+	for _, ri := range recipientInfos {
+		switch {
+		case ri.Class == asn1.ClassUniversal && ri.Tag == asn1.TagSequence:
+			var ktri recipientInfo
+			if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+				return nil, xerrors.Errorf("pkcs7: unmarshaling recipient info: %w", err)
+			}
+			res = append(res, ktri.IssuerAndSerialNumber.exported())
+		case ri.Class == asn1.ClassContextSpecific && ri.Tag == 1:
+			var kari keyAgreeRecipientInfo
+			if _, err := asn1.UnmarshalWithParams(ri.FullBytes, &kari, "tag:1"); err != nil {
+				return nil, xerrors.Errorf("pkcs7: unmarshaling key agreement recipient info: %w", err)
+			}
+			for _, rek := range kari.RecipientEncryptedKeys {
+				res = append(res, rek.RID.exported())
+			}
+		}
+	}
+	return res, nil
+}
+
+// DecryptWithRecipient decrypts encrypted content info for recipient cert and
+// private key and also returns the identifier of the recipient info that was
+// used, so that it can be recorded for audit
+func (p7 *PKCS7) DecryptWithRecipient(cert *x509.Certificate, pk crypto.PrivateKey) ([]byte, IssuerAndSerial, error) {
+	recipientInfos, ok := p7.recipientInfos()
+	if !ok {
+		return nil, IssuerAndSerial{}, ErrNotEncryptedContent
 	}
-	if priv := pk.(*rsa.PrivateKey); priv != nil {
-		var contentKey []byte
-		contentKey, err := rsa.DecryptPKCS1v15(rand.Reader, priv, recipient.EncryptedKey)
-		if err != nil {
-			return nil, err
+	contentKey, recipient, err := decryptKey(recipientInfos, cert, pk, p7.MinRecipientKeyBits)
+	if err != nil {
+		return nil, IssuerAndSerial{}, err
+	}
+	var content []byte
+	switch data := p7.raw.(type) {
+	case envelopedData:
+		content, err = data.EncryptedContentInfo.decrypt(contentKey)
+	case authEnvelopedData:
+		content, err = data.decrypt(contentKey)
+	}
+	if err != nil {
+		return nil, IssuerAndSerial{}, err
+	}
+	return content, recipient.exported(), nil
+}
+
+// ErrWeakRecipientKey is returned by Decrypt when the RSA key of the recipient
+// is shorter than PKCS7.MinRecipientKeyBits
+var ErrWeakRecipientKey = xerrors.New("pkcs7: recipient key is below the minimum size")
+
+// decryptKey recovers the content encryption key from the recipient info
+// addressed to cert, either key transport for RSA or key agreement for EC keys.
+// RSA keys shorter than minBits are rejected.
+func decryptKey(recipients []asn1.RawValue, cert *x509.Certificate, pk crypto.PrivateKey, minBits int) ([]byte, issuerAndSerial, error) {
+	for _, ri := range recipients {
+		switch {
+		case ri.Class == asn1.ClassUniversal && ri.Tag == asn1.TagSequence:
+			var ktri recipientInfo
+			if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+				return nil, issuerAndSerial{}, xerrors.Errorf("unmarshaling recipient info: %w", err)
+			}
+			if !isCertMatchForIssuerAndSerial(cert, ktri.IssuerAndSerialNumber) {
+				continue
+			}
+			priv, ok := pk.(crypto.Decrypter)
+			if !ok {
+				return nil, issuerAndSerial{}, xerrors.Errorf("decrypting key transport recipient: %w", ErrUnsupportedAlgorithm)
+			}
+			pub, ok := priv.Public().(*rsa.PublicKey)
+			if !ok {
+				return nil, issuerAndSerial{}, xerrors.Errorf("decrypting key transport recipient: %w", ErrUnsupportedAlgorithm)
+			}
+			if bits := pub.N.BitLen(); bits < minBits {
+				return nil, issuerAndSerial{}, xerrors.Errorf("%d bit key: %w", bits, ErrWeakRecipientKey)
+			}
+			var key []byte
+			var err error
+			if ktri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP) {
+				var opts *rsa.OAEPOptions
+				if opts, err = parseOAEPOptions(ktri.KeyEncryptionAlgorithm); err != nil {
+					return nil, issuerAndSerial{}, err
+				}
+				key, err = priv.Decrypt(rand.Reader, ktri.EncryptedKey, opts)
+			} else {
+				// nil options select PKCS #1 v1.5 decryption
+				key, err = priv.Decrypt(rand.Reader, ktri.EncryptedKey, nil)
+			}
+			if err != nil {
+				return nil, issuerAndSerial{}, err
+			}
+			return key, ktri.IssuerAndSerialNumber, nil
+		case ri.Class == asn1.ClassContextSpecific && ri.Tag == 1:
+			var kari keyAgreeRecipientInfo
+			if _, err := asn1.UnmarshalWithParams(ri.FullBytes, &kari, "tag:1"); err != nil {
+				return nil, issuerAndSerial{}, xerrors.Errorf("unmarshaling key agreement recipient info: %w", err)
+			}
+			for _, rek := range kari.RecipientEncryptedKeys {
+				if !isCertMatchForIssuerAndSerial(cert, rek.RID) {
+					continue
+				}
+				priv, ok := pk.(*ecdsa.PrivateKey)
+				if !ok {
+					return nil, issuerAndSerial{}, xerrors.Errorf("decrypting key agreement recipient: %w", ErrUnsupportedAlgorithm)
+				}
+				key, err := kari.decryptKey(rek.EncryptedKey, priv)
+				if err != nil {
+					return nil, issuerAndSerial{}, err
+				}
+				return key, rek.RID, nil
+			}
 		}
-		return data.EncryptedContentInfo.decrypt(contentKey)
 	}
-	fmt.Printf("Unsupported Private Key: %v\n", pk)
-	return nil, ErrUnsupportedAlgorithm
+	return nil, issuerAndSerial{}, xerrors.New("pkcs7: no enveloped recipient for provided certificate")
 }
 
 var oidEncryptionAlgorithmDESCBC = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7}
@@ -403,26 +1369,7 @@ func (eci encryptedContentInfo) decrypt(key []byte) ([]byte, error) {
 		return nil, ErrUnsupportedAlgorithm
 	}
 
-	// EncryptedContent can either be constructed of multple OCTET STRINGs
-	// or _be_ a tagged OCTET STRING
-	var cyphertext []byte
-	if eci.EncryptedContent.IsCompound {
-		// Complex case to concat all of the children OCTET STRINGs
-		var buf bytes.Buffer
-		cypherbytes := eci.EncryptedContent.Bytes
-		for {
-			var part []byte
-			cypherbytes, _ = asn1.Unmarshal(cypherbytes, &part)
-			buf.Write(part)
-			if cypherbytes == nil {
-				break
-			}
-		}
-		cyphertext = buf.Bytes()
-	} else {
-		// Simple case, the bytes _are_ the cyphertext
-		cyphertext = eci.EncryptedContent.Bytes
-	}
+	cyphertext := eci.ciphertext()
 
 	var block cipher.Block
 	var err error
@@ -443,38 +1390,16 @@ func (eci encryptedContentInfo) decrypt(key []byte) ([]byte, error) {
 	}
 
 	if alg.Equal(oidEncryptionAlgorithmAES128GCM) {
-		params := aesGCMParameters{}
-		paramBytes := eci.ContentEncryptionAlgorithm.Parameters.Bytes
-
-		_, err := asn1.Unmarshal(paramBytes, &params)
-		if err != nil {
-			return nil, err
-		}
-
-		gcm, err := cipher.NewGCM(block)
-		if err != nil {
-			return nil, err
-		}
-
-		if len(params.Nonce) != gcm.NonceSize() {
-			return nil, xerrors.New("pkcs7: encryption algorithm parameters are incorrect")
-		}
-		if params.ICVLen != gcm.Overhead() {
-			return nil, xerrors.New("pkcs7: encryption algorithm parameters are incorrect")
-		}
-
-		plaintext, err := gcm.Open(nil, params.Nonce, cyphertext, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		return plaintext, nil
+		return eci.openAESGCM(block, cyphertext, nil)
 	}
 
 	iv := eci.ContentEncryptionAlgorithm.Parameters.Bytes
 	if len(iv) != block.BlockSize() {
 		return nil, xerrors.New("pkcs7: encryption algorithm parameters are malformed")
 	}
+	if len(cyphertext) == 0 || len(cyphertext)%block.BlockSize() != 0 {
+		return nil, xerrors.New("pkcs7: encrypted content is not a whole number of blocks")
+	}
 	mode := cipher.NewCBCDecrypter(block, iv)
 	plaintext := make([]byte, len(cyphertext))
 	mode.CryptBlocks(plaintext, cyphertext)
@@ -484,17 +1409,81 @@ func (eci encryptedContentInfo) decrypt(key []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-func selectRecipientForCertificate(recipients []recipientInfo, cert *x509.Certificate) recipientInfo {
-	for _, recp := range recipients {
-		if isCertMatchForIssuerAndSerial(cert, recp.IssuerAndSerialNumber) {
-			return recp
+// ciphertext returns the encrypted content, which can either be constructed
+// of multiple OCTET STRINGs or _be_ a tagged OCTET STRING
+func (eci encryptedContentInfo) ciphertext() []byte {
+	if !eci.EncryptedContent.IsCompound {
+		// Simple case, the bytes _are_ the cyphertext
+		return eci.EncryptedContent.Bytes
+	}
+	// Complex case to concat all of the children OCTET STRINGs
+	var buf bytes.Buffer
+	cypherbytes := eci.EncryptedContent.Bytes
+	for {
+		var part []byte
+		cypherbytes, _ = asn1.Unmarshal(cypherbytes, &part)
+		buf.Write(part)
+		if cypherbytes == nil {
+			break
 		}
 	}
-	return recipientInfo{}
+	return buf.Bytes()
+}
+
+// openAESGCM decrypts and authenticates ciphertext, which ends with the tag,
+// with the AES-GCM parameters of eci and the additional data
+func (eci encryptedContentInfo) openAESGCM(block cipher.Block, ciphertext, additionalData []byte) ([]byte, error) {
+	params := aesGCMParameters{}
+	paramBytes := eci.ContentEncryptionAlgorithm.Parameters.Bytes
+
+	_, err := asn1.Unmarshal(paramBytes, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params.Nonce) != gcm.NonceSize() {
+		return nil, xerrors.New("pkcs7: encryption algorithm parameters are incorrect")
+	}
+	if params.ICVLen != gcm.Overhead() {
+		return nil, xerrors.New("pkcs7: encryption algorithm parameters are incorrect")
+	}
+
+	return gcm.Open(nil, params.Nonce, ciphertext, additionalData)
+}
+
+// ContentEncryptionIV returns the content encryption algorithm of a parsed
+// EnvelopedData or AuthEnvelopedData and the IV, or the nonce for AES-GCM,
+// found in its parameters, to help diagnosing interoperability problems. The
+// IV is returned as encoded, without checking its length.
+func (p7 *PKCS7) ContentEncryptionIV() (asn1.ObjectIdentifier, []byte, error) {
+	var eci encryptedContentInfo
+	switch data := p7.raw.(type) {
+	case envelopedData:
+		eci = data.EncryptedContentInfo
+	case authEnvelopedData:
+		eci = data.AuthEncryptedContentInfo
+	default:
+		return nil, nil, ErrNotEncryptedContent
+	}
+	alg := eci.ContentEncryptionAlgorithm
+	if alg.Algorithm.Equal(oidEncryptionAlgorithmAES128GCM) {
+		// like decrypt, read the parameters from the contents octets
+		var params aesGCMParameters
+		if _, err := asn1.Unmarshal(alg.Parameters.Bytes, &params); err != nil {
+			return nil, nil, xerrors.Errorf("pkcs7: unmarshaling AES-GCM parameters: %w", err)
+		}
+		return alg.Algorithm, params.Nonce, nil
+	}
+	return alg.Algorithm, append([]byte(nil), alg.Parameters.Bytes...), nil
 }
 
 func isCertMatchForIssuerAndSerial(cert *x509.Certificate, ias issuerAndSerial) bool {
-	return cert.SerialNumber.Cmp(ias.SerialNumber) == 0 && bytes.Compare(cert.RawIssuer, ias.IssuerName.FullBytes) == 0
+	return ias.exported().Matches(cert)
 }
 
 func pad(data []byte, blocklen int) ([]byte, error) {
@@ -520,17 +1509,27 @@ func unpad(data []byte, blocklen int) ([]byte, error) {
 	// the last byte is the length of padding
 	padlen := int(data[len(data)-1])
 
-	// check padding integrity, all bytes should be the same
-	pad := data[len(data)-padlen:]
-	for _, padbyte := range pad {
-		if padbyte != byte(padlen) {
-			return nil, xerrors.New("invalid padding")
-		}
+	// check padding integrity, all bytes should be the same. The whole last
+	// block is examined whatever the padding length, so that the time taken
+	// does not tell how much of the padding was correct.
+	good := subtle.ConstantTimeLessOrEq(1, padlen) & subtle.ConstantTimeLessOrEq(padlen, blocklen)
+	last := data[len(data)-blocklen:]
+	for i := 1; i <= blocklen; i++ {
+		inPad := subtle.ConstantTimeLessOrEq(i, padlen)
+		match := subtle.ConstantTimeByteEq(last[blocklen-i], byte(padlen))
+		good &= subtle.ConstantTimeSelect(inPad, match, 1)
+	}
+	if good != 1 {
+		return nil, ErrInvalidPadding
 	}
 
 	return data[:len(data)-padlen], nil
 }
 
+// ErrInvalidPadding is returned when decrypted CBC content does not end in
+// valid PKCS #7 padding, which usually means a wrong key or corrupted content
+var ErrInvalidPadding = xerrors.New("pkcs7: invalid padding")
+
 func unmarshalAttribute(attrs []attribute, attributeType asn1.ObjectIdentifier, out interface{}) error {
 	for _, attr := range attrs {
 		if attr.Type.Equal(attributeType) {
@@ -541,11 +1540,122 @@ func unmarshalAttribute(attrs []attribute, attributeType asn1.ObjectIdentifier,
 	return xerrors.New("pkcs7: attribute type not in attributes")
 }
 
+func (p7 *PKCS7) signer(index int) (*signerInfo, error) {
+	if index < 0 || index >= p7.SignerCount() {
+		return nil, xerrors.Errorf("pkcs7: signer index %d out of range", index)
+	}
+	if p7.lazySigners != nil {
+		signer, err := p7.decodeSigner(index)
+		if err != nil {
+			return nil, err
+		}
+		return &signer, nil
+	}
+	return &p7.Signers[index], nil
+}
+
+// SignatureValue returns a copy of the signature value, the encryptedDigest,
+// of the signer at index, e.g. for timestamping or archiving it
+func (p7 *PKCS7) SignatureValue(index int) ([]byte, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), signer.EncryptedDigest...), nil
+}
+
+// RawSignerInfo returns the DER encoding of the signer info at index exactly
+// as it appeared in the parsed message
+func (p7 *PKCS7) RawSignerInfo(index int) ([]byte, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	if len(signer.Raw) > 0 {
+		return signer.Raw, nil
+	}
+	return asn1.Marshal(*signer)
+}
+
+// TimestampImprint returns the digest of the signature value of the signer at
+// index computed with hash h. It is the message imprint to be sent to an
+// RFC 3161 time-stamping authority to timestamp the signature.
+func (p7 *PKCS7) TimestampImprint(index int, h crypto.Hash) ([]byte, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Available() {
+		return nil, xerrors.Errorf("pkcs7: timestamp imprint hash %v: %w", h, ErrUnsupportedAlgorithm)
+	}
+	hasher := h.New()
+	hasher.Write(signer.EncryptedDigest)
+	return hasher.Sum(nil), nil
+}
+
+// RawAttribute is a parsed attribute with every value of its SET left as
+// raw ASN.1 for the caller to decode
+type RawAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue
+}
+
+// SignedAttributes returns the authenticated attributes of the signer at
+// index
+func (p7 *PKCS7) SignedAttributes(index int) ([]RawAttribute, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	return exportAttributes(signer.AuthenticatedAttributes)
+}
+
+// UnsignedAttributes returns the unauthenticated attributes of the signer at
+// index, such as timestamp tokens or counter-signatures
+func (p7 *PKCS7) UnsignedAttributes(index int) ([]RawAttribute, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	return exportAttributes(signer.UnauthenticatedAttributes)
+}
+
+// UnprotectedAttributes returns the unprotected attributes of a parsed
+// EnvelopedData or the unauthenticated attributes of an AuthEnvelopedData
+func (p7 *PKCS7) UnprotectedAttributes() ([]RawAttribute, error) {
+	switch data := p7.raw.(type) {
+	case envelopedData:
+		return exportAttributes(data.UnprotectedAttrs)
+	case authEnvelopedData:
+		return exportAttributes(data.UnauthAttrs)
+	}
+	return nil, ErrNotEncryptedContent
+}
+
+func exportAttributes(attrs []attribute) ([]RawAttribute, error) {
+	res := make([]RawAttribute, len(attrs))
+	for i, attr := range attrs {
+		res[i].Type = attr.Type
+		for rest := attr.Value.Bytes; len(rest) > 0; {
+			var value asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &value); err != nil {
+				return nil, xerrors.Errorf("pkcs7: unmarshaling value of attribute %v: %w", attr.Type, err)
+			}
+			res[i].Values = append(res[i].Values, value)
+		}
+	}
+	return res, nil
+}
+
 // UnmarshalSignedAttribute decodes a single attribute from the signer info
 func (p7 *PKCS7) UnmarshalSignedAttribute(attributeType asn1.ObjectIdentifier, out interface{}) error {
-	sd, ok := p7.raw.(signedData)
-	if !ok {
-		return xerrors.New("pkcs7: payload is not signedData content")
+	if err := p7.loadSigners(); err != nil {
+		return err
+	}
+	sd, err := p7.signedData()
+	if err != nil {
+		return err
 	}
 	if len(sd.SignerInfos) < 1 {
 		return xerrors.New("pkcs7: payload has no signers")
@@ -560,8 +1670,14 @@ type SignedData struct {
 	sd            signedData
 	certs         []*x509.Certificate
 	messageDigest []byte
-	hashes        map[crypto.Hash]hash.Hash
-	pkeys         []crypto.PrivateKey
+	// content holds the signed content for digest algorithms other than
+	// SHA-256, nil when only the SHA-256 digest is known
+	content  []byte
+	hashes   map[crypto.Hash]hash.Hash
+	pkeys    []crypto.PrivateKey
+	configs  []SignerInfoConfig
+	detached bool
+	compress bool
 }
 
 // Attribute represents a key value pair attribute. Value must be marshalable byte
@@ -574,6 +1690,114 @@ type Attribute struct {
 // SignerInfoConfig are optional values to include when adding a signer
 type SignerInfoConfig struct {
 	ExtraSignedAttributes []Attribute
+	// Rand is the source of randomness for the signature of this signer,
+	// defaults to crypto/rand.Reader. It is used for nothing else: content
+	// encryption keys and IVs always read crypto/rand.Reader.
+	Rand io.Reader
+	// SigningTime is put into the signing-time attribute, defaults to the
+	// current time
+	SigningTime time.Time
+	// OmitCertificate leaves the signer certificate out of the message, the
+	// verifier then has to add it to PKCS7.Certificates before verifying
+	OmitCertificate bool
+	// NullSignatureParameters makes the rsaEncryption signature algorithm
+	// identifier carry explicit NULL parameters, as required by RFC 3370 and
+	// expected by some verifiers. By default the parameters are absent.
+	NullSignatureParameters bool
+	// DeterministicECDSA derives ECDSA nonces from the key and the signed
+	// attributes as in RFC 6979, so that signing the same attributes twice
+	// yields the same signature and no randomness is used. By default ECDSA
+	// signatures are randomized.
+	DeterministicECDSA bool
+	// SkipSigningTime leaves the signing-time attribute out, so that the
+	// signature does not disclose when it was made. To drop it from an
+	// existing signature use SignedData.RemoveSigningTime.
+	SkipSigningTime bool
+	// NoAttributes signs the message digest of the content directly and
+	// leaves the signed attributes out altogether, as some legacy verifiers
+	// expect. ExtraSignedAttributes and a SignatureHash other than the
+	// DigestAlgorithm cannot be used with it.
+	NoAttributes bool
+	// DigestAlgorithm is the hash used for the message digest and the
+	// signature, SHA-256 when zero. Hashes other than SHA-256 need the
+	// content, so they cannot be used with NewSignedDataFromDigest.
+	DigestAlgorithm crypto.Hash
+	// SignatureHash is the hash of the signature scheme applied to the signed
+	// attributes, the same as DigestAlgorithm when zero. When the two differ
+	// the signature algorithm is encoded as sha*WithRSAEncryption or
+	// ecdsa-with-SHA* so that verifiers learn the signature hash.
+	SignatureHash crypto.Hash
+	// IncludeChainFrom, when set, makes AddSigner embed the intermediate
+	// certificates between the signer and its root, found by verifying the
+	// signer certificate against the pool at the signing time. The pool must
+	// hold the root too, which is left out of the message.
+	IncludeChainFrom *x509.CertPool
+}
+
+func (config SignerInfoConfig) digestAlgorithm() crypto.Hash {
+	if config.DigestAlgorithm == 0 {
+		return crypto.SHA256
+	}
+	return config.DigestAlgorithm
+}
+
+func (config SignerInfoConfig) signatureHash() crypto.Hash {
+	if config.SignatureHash == 0 {
+		return config.digestAlgorithm()
+	}
+	return config.SignatureHash
+}
+
+func (config SignerInfoConfig) random() io.Reader {
+	if config.Rand == nil {
+		return rand.Reader
+	}
+	return config.Rand
+}
+
+// signatureRandom returns the randomness passed to the signing key, nil
+// requesting RFC 6979 nonces from ECDSA keys
+func (config SignerInfoConfig) signatureRandom() io.Reader {
+	if config.DeterministicECDSA {
+		return nil
+	}
+	return config.random()
+}
+
+// intermediates returns the certificates between cert and its root in the
+// IncludeChainFrom pool, nil when no pool is set
+func (config SignerInfoConfig) intermediates(cert *x509.Certificate) ([]*x509.Certificate, error) {
+	if config.IncludeChainFrom == nil {
+		return nil, nil
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Intermediates: config.IncludeChainFrom,
+		Roots:         config.IncludeChainFrom,
+		CurrentTime:   config.signingTime(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("pkcs7: building signer chain: %w", err)
+	}
+	// every certificate in the pool is trusted as a root, so the longest
+	// chain is the one that reaches the real root
+	chain := chains[0]
+	for _, c := range chains[1:] {
+		if len(c) > len(chain) {
+			chain = c
+		}
+	}
+	if len(chain) < 2 {
+		return nil, nil
+	}
+	return chain[1 : len(chain)-1], nil
+}
+
+func (config SignerInfoConfig) signingTime() time.Time {
+	if config.SigningTime.IsZero() {
+		return time.Now()
+	}
+	return config.SigningTime
 }
 
 // NewSignedData initializes a SignedData with content
@@ -586,120 +1810,721 @@ func NewSignedData(data []byte) (*SignedData, error) {
 		ContentType: oidData,
 		Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: content, IsCompound: true},
 	}
-	digAlg := pkix.AlgorithmIdentifier{
-		Algorithm: oidSHA256,
+	digAlg := pkix.AlgorithmIdentifier{
+		Algorithm: oidSHA256,
+	}
+	h := crypto.SHA256.New()
+	h.Write(data)
+	md := h.Sum(nil)
+	sd := signedData{
+		ContentInfo:                ci,
+		Version:                    1,
+		DigestAlgorithmIdentifiers: []pkix.AlgorithmIdentifier{digAlg},
+	}
+	return &SignedData{sd: sd, messageDigest: md, content: data}, nil
+}
+
+// NewSignedDataWithContentType initializes a SignedData encapsulating the DER
+// encoded content of contentType, e.g. a TSTInfo, instead of id-data. The
+// content is carried in an OCTET STRING as CMS requires, so that PKCS7.Content
+// returns it as given once parsed.
+func NewSignedDataWithContentType(data []byte, contentType asn1.ObjectIdentifier) (*SignedData, error) {
+	sd, err := NewSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	sd.sd.ContentInfo.ContentType = contentType
+	if !contentType.Equal(oidData) {
+		sd.sd.Version = 3
+	}
+	return sd, nil
+}
+
+// NewSignedDataFromDigest initializes a detached SignedData for content the
+// signer never sees. The digest must be the SHA-256 hash of the content and
+// is put into the message-digest attribute, contentType is the type of the
+// content, e.g. id-data.
+func NewSignedDataFromDigest(digest []byte, contentType asn1.ObjectIdentifier) (*SignedData, error) {
+	if len(digest) != crypto.SHA256.Size() {
+		return nil, xerrors.Errorf("pkcs7: expected SHA-256 digest, got %d bytes", len(digest))
+	}
+	sd := signedData{
+		ContentInfo:                contentInfo{ContentType: contentType},
+		Version:                    1,
+		DigestAlgorithmIdentifiers: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+	}
+	if !contentType.Equal(oidData) {
+		sd.Version = 3
+	}
+	return &SignedData{sd: sd, messageDigest: digest}, nil
+}
+
+// NewSignedDataFrom returns a SignedData holding the content, certificates
+// and signers of the parsed signed message p7, so that more signers can be
+// added to it with AddSigner. The existing signer infos are kept intact. For
+// detached messages the signed content must be supplied, otherwise content is
+// ignored and the embedded content is digested.
+func NewSignedDataFrom(p7 *PKCS7, content []byte) (*SignedData, error) {
+	if err := p7.loadSigners(); err != nil {
+		return nil, err
+	}
+	sd, err := p7.signedData()
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.ContentInfo.Content.Bytes) > 0 {
+		content = p7.signedContent()
+	}
+	var hasSHA256 bool
+	for _, aid := range sd.DigestAlgorithmIdentifiers {
+		hasSHA256 = hasSHA256 || aid.Algorithm.Equal(oidSHA256)
+	}
+	if !hasSHA256 {
+		sd.DigestAlgorithmIdentifiers = append(sd.DigestAlgorithmIdentifiers, pkix.AlgorithmIdentifier{Algorithm: oidSHA256})
+	}
+	h := crypto.SHA256.New()
+	h.Write(content)
+	return &SignedData{
+		sd:            sd,
+		certs:         append([]*x509.Certificate(nil), p7.Certificates...),
+		messageDigest: h.Sum(nil),
+		content:       content,
+	}, nil
+}
+
+// Detach re-encodes the parsed signed message without its embedded content
+// and returns the DER of the resulting detached signature. The certificates,
+// CRLs and signer infos are kept byte for byte, so the signatures remain valid
+// against the original content.
+func (p7 *PKCS7) Detach() ([]byte, error) {
+	if err := p7.loadSigners(); err != nil {
+		return nil, err
+	}
+	sd, err := p7.signedData()
+	if err != nil {
+		return nil, err
+	}
+	sd.ContentInfo = contentInfo{ContentType: sd.ContentInfo.ContentType}
+	return marshalContentInfo(oidSignedData, sd)
+}
+
+// Clone returns a copy of the parsed message whose signer infos can be
+// changed with AddUnsignedAttribute and RemoveUnsignedAttributes without
+// affecting p7. The copy is re-encoded with WriteTo, and signers can be added
+// to it with NewSignedDataFrom.
+func (p7 *PKCS7) Clone() *PKCS7 {
+	clone := *p7
+	clone.Signers = append([]signerInfo(nil), p7.Signers...)
+	if sd, ok := p7.raw.(signedData); ok {
+		sd.SignerInfos = clone.Signers
+		clone.raw = sd
+	}
+	return &clone
+}
+
+// AddCertificates appends the certificates not yet present to the
+// certificates of a parsed SignedData, e.g. intermediates needed for long-term
+// validation. Only the certificates field is encoded again, the content and
+// the signer infos are kept byte for byte, so the signatures remain valid.
+// Re-encode the message with WriteTo.
+func (p7 *PKCS7) AddCertificates(certs ...*x509.Certificate) error {
+	sd, err := p7.signedData()
+	if err != nil {
+		return err
+	}
+	var existing []byte
+	if len(sd.Certificates.Raw) > 0 {
+		var wrapper asn1.RawValue
+		if _, err := asn1.Unmarshal(sd.Certificates.Raw, &wrapper); err != nil {
+			return xerrors.Errorf("pkcs7: unmarshaling certificates: %w", err)
+		}
+		existing = wrapper.Bytes
+	}
+	all := append([]*x509.Certificate(nil), p7.Certificates...)
+	buf := bytes.NewBuffer(append([]byte(nil), existing...))
+	for _, cert := range certs {
+		if containsCertificate(all, cert) {
+			continue
+		}
+		buf.Write(cert.Raw)
+		all = append(all, cert)
+	}
+	if len(all) == len(p7.Certificates) {
+		return nil
+	}
+	raw, err := marshalCertificateBytes(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	sd.Certificates = raw
+	p7.raw = sd
+	p7.Certificates = all
+	p7.der = nil
+	return nil
+}
+
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if bytes.Equal(c.Raw, cert.Raw) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddUnsignedAttribute appends an unauthenticated attribute, e.g. a timestamp
+// token, to the signer at index. The rest of the signer info, including the
+// authenticated attributes and the signature, is kept byte for byte, so the
+// signature remains valid.
+func (p7 *PKCS7) AddUnsignedAttribute(index int, attrType asn1.ObjectIdentifier, value interface{}) error {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return err
+	}
+	var attrs attributes
+	attrs.Add(attrType, value)
+	added, err := attrs.ForMarshaling()
+	if err != nil {
+		return err
+	}
+	unsigned := append(append([]attribute(nil), signer.UnauthenticatedAttributes...), added...)
+	return p7.setUnsignedAttributes(index, unsigned)
+}
+
+// RemoveUnsignedAttributes removes the unauthenticated attributes of attrType
+// from the signer at index, keeping the rest of the signer info byte for
+// byte.
+func (p7 *PKCS7) RemoveUnsignedAttributes(index int, attrType asn1.ObjectIdentifier) error {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return err
+	}
+	var unsigned []attribute
+	for _, attr := range signer.UnauthenticatedAttributes {
+		if !attr.Type.Equal(attrType) {
+			unsigned = append(unsigned, attr)
+		}
+	}
+	return p7.setUnsignedAttributes(index, unsigned)
+}
+
+func (p7 *PKCS7) setUnsignedAttributes(index int, attrs []attribute) error {
+	if err := p7.loadSigners(); err != nil {
+		return err
+	}
+	updated, err := p7.Signers[index].withUnsignedAttributes(attrs)
+	if err != nil {
+		return err
+	}
+	p7.Signers[index] = updated
+	if sd, ok := p7.raw.(signedData); ok {
+		sd.SignerInfos = p7.Signers
+		p7.raw = sd
+	}
+	p7.der = nil
+	return nil
+}
+
+// withUnsignedAttributes returns the signer info with its unauthenticated
+// attributes replaced by attrs. The other fields are copied from the raw
+// encoding instead of being marshaled again, so that re-encoding cannot alter
+// the signed portions.
+func (si signerInfo) withUnsignedAttributes(attrs []attribute) (signerInfo, error) {
+	raw := []byte(si.Raw)
+	if len(raw) == 0 {
+		var err error
+		if raw, err = asn1.Marshal(si); err != nil {
+			return signerInfo{}, err
+		}
+	}
+	fields, err := rawFields(raw)
+	if err != nil {
+		return signerInfo{}, xerrors.Errorf("pkcs7: unmarshaling signer info: %w", err)
+	}
+	var body []byte
+	for _, field := range fields {
+		if field.Class == asn1.ClassContextSpecific && field.Tag == 1 {
+			continue
+		}
+		body = append(body, field.FullBytes...)
+	}
+	if len(attrs) > 0 {
+		unsigned, err := asn1.MarshalWithParams(attrs, "set,tag:1")
+		if err != nil {
+			return signerInfo{}, xerrors.Errorf("pkcs7: marshaling unsigned attributes: %w", err)
+		}
+		body = append(body, unsigned...)
+	}
+	encoded, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: body})
+	if err != nil {
+		return signerInfo{}, err
+	}
+	var updated signerInfo
+	if _, err = asn1.Unmarshal(encoded, &updated); err != nil {
+		return signerInfo{}, xerrors.Errorf("pkcs7: unmarshaling signer info: %w", err)
+	}
+	return updated, nil
+}
+
+// WriteTo writes the DER encoding of the parsed message into w. Parts kept
+// from the parsed input, such as certificates and signer infos, are written
+// byte for byte, so the output re-parses into an equal message.
+func (p7 *PKCS7) WriteTo(w io.Writer) (int64, error) {
+	if err := p7.loadSigners(); err != nil {
+		return 0, err
+	}
+	var contentType asn1.ObjectIdentifier
+	content := p7.raw
+	switch raw := p7.raw.(type) {
+	case unknownContent:
+		contentType, content = raw.contentType, raw.content
+	case signedData:
+		contentType = oidSignedData
+	case envelopedData:
+		contentType = oidEnvelopedData
+	case authenticatedData:
+		contentType = oidAuthenticatedData
+	case compressedData:
+		contentType = oidCompressedData
+	case authEnvelopedData:
+		contentType = oidAuthEnvelopedData
+	default:
+		return 0, xerrors.New("pkcs7: message was not parsed and cannot be encoded")
+	}
+	der, err := marshalContentInfo(contentType, content)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(der)
+	return int64(n), err
+}
+
+// marshalContentInfo encodes content wrapped in a ContentInfo of contentType
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	inner, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: inner, IsCompound: true},
+	})
+}
+
+type attributes struct {
+	types  []asn1.ObjectIdentifier
+	values []interface{}
+}
+
+// Add adds the attribute, maintaining insertion order
+func (attrs *attributes) Add(attrType asn1.ObjectIdentifier, value interface{}) {
+	attrs.types = append(attrs.types, attrType)
+	attrs.values = append(attrs.values, value)
+}
+
+type sortableAttribute struct {
+	SortKey   []byte
+	Attribute attribute
+}
+
+type attributeSet []sortableAttribute
+
+func (sa attributeSet) Len() int {
+	return len(sa)
+}
+
+func (sa attributeSet) Less(i, j int) bool {
+	return bytes.Compare(sa[i].SortKey, sa[j].SortKey) < 0
+}
+
+func (sa attributeSet) Swap(i, j int) {
+	sa[i], sa[j] = sa[j], sa[i]
+}
+
+func (sa attributeSet) Attributes() []attribute {
+	attrs := make([]attribute, len(sa))
+	for i, attr := range sa {
+		attrs[i] = attr.Attribute
+	}
+	return attrs
+}
+
+func (attrs *attributes) ForMarshaling() ([]attribute, error) {
+	sortables := make(attributeSet, len(attrs.types))
+	for i := range sortables {
+		attrType := attrs.types[i]
+		attrValue := attrs.values[i]
+		asn1Value, err := asn1.Marshal(attrValue)
+		if err != nil {
+			return nil, xerrors.Errorf("marshaling value for %v: %w", attrType, err)
+		}
+		attr := attribute{
+			Type:  attrType,
+			Value: asn1.RawValue{Tag: 17, IsCompound: true, Bytes: asn1Value}, // 17 == SET tag
+		}
+		encoded, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, xerrors.Errorf("marshaling attribute %v: %w", attr, err)
+		}
+		sortables[i] = sortableAttribute{
+			SortKey:   encoded,
+			Attribute: attr,
+		}
+	}
+	sort.Sort(sortables)
+	return sortables.Attributes(), nil
+}
+
+// AddSigner signs attributes about the content and adds certificate to payload.
+// Besides *rsa.PrivateKey and *ecdsa.PrivateKey, pkey may be any crypto.Signer
+// with an RSA or ECDSA public key, such as a key kept in an HSM. RSA signers
+// must produce PKCS #1 v1.5 signatures when given a crypto.Hash.
+func (sd *SignedData) AddSigner(cert *x509.Certificate, pkey crypto.PrivateKey, config SignerInfoConfig) error {
+	if err := checkSignerKey(cert, pkey); err != nil {
+		return err
+	}
+	if !config.signatureHash().Available() {
+		return xerrors.Errorf("pkcs7: signature hash %v: %w", config.signatureHash(), ErrUnsupportedAlgorithm)
+	}
+	if config.NoAttributes && (len(config.ExtraSignedAttributes) > 0 || config.signatureHash() != config.digestAlgorithm()) {
+		return xerrors.New("pkcs7: NoAttributes cannot be combined with signed attributes or a separate signature hash")
+	}
+	if sd.w != nil {
+		// the streaming encoder signs once the content is digested
+		return sd.addSignerInfo(cert, pkey, nil, nil, config)
+	}
+	hash := config.digestAlgorithm()
+	messageDigest, err := sd.messageDigestFor(hash)
+	if err != nil {
+		return err
+	}
+	finalAttrs, signature, err := sd.signMessageDigest(messageDigest, pkey, config)
+	if err != nil {
+		return err
+	}
+	return sd.addSignerInfo(cert, pkey, finalAttrs, signature, config)
+}
+
+// signMessageDigest returns the signed attributes for messageDigest and their
+// signature, or no attributes and the signature of messageDigest itself when
+// the config asks for NoAttributes
+func (sd *SignedData) signMessageDigest(messageDigest []byte, pkey crypto.PrivateKey, config SignerInfoConfig) ([]attribute, []byte, error) {
+	if config.NoAttributes {
+		signature, err := signDigest(messageDigest, pkey, config.digestAlgorithm(), config.signatureRandom())
+		if err != nil {
+			return nil, nil, xerrors.Errorf("signing digest: %w", err)
+		}
+		return nil, signature, nil
+	}
+	finalAttrs, err := sd.signedAttributes(messageDigest, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := signAttributes(finalAttrs, pkey, config.signatureHash(), config.signatureRandom())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("signing attrs: %w", err)
+	}
+	return finalAttrs, signature, nil
+}
+
+// messageDigestFor returns the digest of the content computed with hash
+func (sd *SignedData) messageDigestFor(hash crypto.Hash) ([]byte, error) {
+	if hash == crypto.SHA256 {
+		return sd.messageDigest, nil
+	}
+	if !hash.Available() {
+		return nil, xerrors.Errorf("pkcs7: digest algorithm %v: %w", hash, ErrUnsupportedAlgorithm)
+	}
+	if sd.content == nil {
+		return nil, xerrors.Errorf("pkcs7: %v digest needs the content, only its SHA-256 digest is known", hash)
+	}
+	h := hash.New()
+	h.Write(sd.content)
+	return h.Sum(nil), nil
+}
+
+// ErrUnsupportedPublicKeyAlgorithm is returned when adding a signer whose
+// certificate or private key is of a type that cannot be used for signing
+var ErrUnsupportedPublicKeyAlgorithm = xerrors.New("pkcs7: unsupported public key algorithm, only RSA and ECDSA keys can sign")
+
+// checkSignerKey makes sure the certificate holds an RSA or ECDSA key and that
+// the private key, if any, is of the same kind
+func checkSignerKey(cert *x509.Certificate, pkey crypto.PrivateKey) error {
+	var ok bool
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		_, ok = signerPublicKey(pkey).(*rsa.PublicKey)
+	case *ecdsa.PublicKey:
+		_, ok = signerPublicKey(pkey).(*ecdsa.PublicKey)
+	default:
+		return xerrors.Errorf("certificate key %T: %w", cert.PublicKey, ErrUnsupportedPublicKeyAlgorithm)
+	}
+	if pkey != nil && !ok {
+		return xerrors.Errorf("private key %T: %w", pkey, ErrUnsupportedPublicKeyAlgorithm)
+	}
+	return nil
+}
+
+// signerPublicKey returns the public key of pkey, nil when it cannot sign
+func signerPublicKey(pkey crypto.PrivateKey) crypto.PublicKey {
+	if signer, ok := pkey.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+// addSignerInfo appends the signer info with the signed attributes and their
+// signature, adding the certificate unless the config omits it
+func (sd *SignedData) addSignerInfo(cert *x509.Certificate, pkey crypto.PrivateKey, attrs []attribute, signature []byte, config SignerInfoConfig) error {
+	ias, err := cert2issuerAndSerial(cert)
+	if err != nil {
+		return err
+	}
+	chain, err := config.intermediates(cert)
+	if err != nil {
+		return err
+	}
+
+	hash := config.digestAlgorithm()
+	digestOID, ok := HashToOID(hash)
+	if !ok {
+		return xerrors.Errorf("pkcs7: digest algorithm %v: %w", hash, ErrUnsupportedAlgorithm)
+	}
+	signer := signerInfo{
+		AuthenticatedAttributes:   attrs,
+		DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: digestOID},
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSA},
+		IssuerAndSerialNumber:     ias,
+		EncryptedDigest:           signature,
+		Version:                   1,
+	}
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); ok {
+		if signer.DigestEncryptionAlgorithm.Algorithm, err = ecdsaSignatureOID(config.signatureHash()); err != nil {
+			return err
+		}
+	} else if config.signatureHash() != hash {
+		if signer.DigestEncryptionAlgorithm.Algorithm, err = rsaSignatureOID(config.signatureHash()); err != nil {
+			return err
+		}
+		signer.DigestEncryptionAlgorithm.Parameters = asn1.RawValue{FullBytes: nullBytes}
+	} else if config.NullSignatureParameters {
+		signer.DigestEncryptionAlgorithm.Parameters = asn1.RawValue{FullBytes: nullBytes}
+	}
+	// create signature of signed attributes
+	if !config.OmitCertificate {
+		sd.certs = append(sd.certs, cert)
+	}
+	for _, parent := range chain {
+		if !containsCertificate(sd.certs, parent) {
+			sd.certs = append(sd.certs, parent)
+		}
+	}
+	sd.addDigestAlgorithm(signer.DigestAlgorithm)
+	sd.sd.SignerInfos = append(sd.sd.SignerInfos, signer)
+	sd.pkeys = append(sd.pkeys, pkey)
+	sd.configs = append(sd.configs, config)
+	return nil
+}
+
+// ecdsaSignatureOID returns the ecdsa-with-SHA* signature algorithm for hash
+func ecdsaSignatureOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	for _, details := range signatureAlgorithmDetails {
+		if details.pubKeyAlgo == x509.ECDSA && details.hash == hash {
+			return details.oid, nil
+		}
+	}
+	return nil, xerrors.Errorf("pkcs7: ECDSA with %v: %w", hash, ErrUnsupportedAlgorithm)
+}
+
+// rsaSignatureOID returns the sha*WithRSAEncryption signature algorithm for
+// hash
+func rsaSignatureOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	for _, details := range signatureAlgorithmDetails {
+		if details.pubKeyAlgo == x509.RSA && details.hash == hash && !isRSAPSS(details.algo) {
+			return details.oid, nil
+		}
 	}
-	h := crypto.SHA256.New()
-	h.Write(data)
-	md := h.Sum(nil)
-	sd := signedData{
-		ContentInfo:                ci,
-		Version:                    1,
-		DigestAlgorithmIdentifiers: []pkix.AlgorithmIdentifier{digAlg},
+	return nil, xerrors.Errorf("pkcs7: RSA with %v: %w", hash, ErrUnsupportedAlgorithm)
+}
+
+// signatureHashOf returns the hash of the signer's signature scheme, which is
+// that of its digest algorithm unless the signature algorithm names another
+func signatureHashOf(signer signerInfo) (crypto.Hash, error) {
+	if algo := getSignatureAlgorithmFromAI(signer.DigestEncryptionAlgorithm); algo != x509.UnknownSignatureAlgorithm {
+		for _, details := range signatureAlgorithmDetails {
+			if details.algo == algo && details.hash != 0 {
+				return details.hash, nil
+			}
+		}
 	}
-	return &SignedData{sd: sd, messageDigest: md}, nil
+	return getHashForOID(signer.DigestAlgorithm.Algorithm)
 }
 
-type attributes struct {
-	types  []asn1.ObjectIdentifier
-	values []interface{}
+// addDigestAlgorithm announces the digest algorithm in the message unless it
+// is already there
+func (sd *SignedData) addDigestAlgorithm(aid pkix.AlgorithmIdentifier) {
+	for _, existing := range sd.sd.DigestAlgorithmIdentifiers {
+		if existing.Algorithm.Equal(aid.Algorithm) {
+			return
+		}
+	}
+	sd.sd.DigestAlgorithmIdentifiers = append(sd.sd.DigestAlgorithmIdentifiers, aid)
 }
 
-// Add adds the attribute, maintaining insertion order
-func (attrs *attributes) Add(attrType asn1.ObjectIdentifier, value interface{}) {
-	attrs.types = append(attrs.types, attrType)
-	attrs.values = append(attrs.values, value)
+// sortDigestAlgorithms puts the digest algorithms in DER SET OF order, that
+// is sorted by their encodings, which older encoding/asn1 does not do
+func (sd *SignedData) sortDigestAlgorithms() error {
+	aids := sd.sd.DigestAlgorithmIdentifiers
+	encoded := make([][]byte, len(aids))
+	for i, aid := range aids {
+		var err error
+		if encoded[i], err = asn1.Marshal(aid); err != nil {
+			return xerrors.Errorf("pkcs7: marshaling digest algorithm: %w", err)
+		}
+	}
+	sort.Sort(byEncoding{aids, encoded})
+	return nil
 }
 
-type sortableAttribute struct {
-	SortKey   []byte
-	Attribute attribute
+type byEncoding struct {
+	aids    []pkix.AlgorithmIdentifier
+	encoded [][]byte
 }
 
-type attributeSet []sortableAttribute
+func (b byEncoding) Len() int { return len(b.aids) }
 
-func (sa attributeSet) Len() int {
-	return len(sa)
+func (b byEncoding) Less(i, j int) bool { return bytes.Compare(b.encoded[i], b.encoded[j]) < 0 }
+
+func (b byEncoding) Swap(i, j int) {
+	b.aids[i], b.aids[j] = b.aids[j], b.aids[i]
+	b.encoded[i], b.encoded[j] = b.encoded[j], b.encoded[i]
 }
 
-func (sa attributeSet) Less(i, j int) bool {
-	return bytes.Compare(sa[i].SortKey, sa[j].SortKey) < 0
+// PrepareDetached starts the two-phase signing of detached content, whose
+// SHA-256 digest was computed elsewhere. It adds a signer for cert with
+// signed attributes carrying the digest and returns the DER encoded
+// attributes that must be signed over SHA-256 with RSA PKCS#1 v1.5 or ECDSA,
+// depending on the certificate key. The signature is attached with Finalize.
+// Any embedded content is dropped while its content type is kept, e.g. the
+// one given to NewSignedDataFromDigest.
+func (sd *SignedData) PrepareDetached(cert *x509.Certificate, digest []byte, config SignerInfoConfig) ([]byte, error) {
+	if len(digest) != crypto.SHA256.Size() {
+		return nil, xerrors.Errorf("pkcs7: expected SHA-256 digest, got %d bytes", len(digest))
+	}
+	if err := checkSignerKey(cert, nil); err != nil {
+		return nil, err
+	}
+	sd.sd.ContentInfo = contentInfo{ContentType: sd.sd.ContentInfo.ContentType}
+	sd.detached = true
+	sd.messageDigest = digest
+	attrs, err := sd.signedAttributes(digest, config)
+	if err != nil {
+		return nil, err
+	}
+	if err = sd.addSignerInfo(cert, nil, attrs, nil, config); err != nil {
+		return nil, err
+	}
+	return sd.TBS(len(sd.sd.SignerInfos) - 1)
 }
 
-func (sa attributeSet) Swap(i, j int) {
-	sa[i], sa[j] = sa[j], sa[i]
+// Finalize attaches the externally produced signature of the attributes
+// returned by PrepareDetached and marshals the message
+func (sd *SignedData) Finalize(signature []byte) ([]byte, error) {
+	if err := sd.SetSignature(len(sd.sd.SignerInfos)-1, signature); err != nil {
+		return nil, xerrors.Errorf("pkcs7: no prepared signer to finalize: %w", err)
+	}
+	return sd.Finish()
 }
 
-func (sa attributeSet) Attributes() []attribute {
-	attrs := make([]attribute, len(sa))
-	for i, attr := range sa {
-		attrs[i] = attr.Attribute
+// TBS returns the to-be-signed bytes of the signer at index, that is the DER
+// encoding of its signed attributes tagged as a SET. An external signer signs
+// them and the signature is put back with SetSignature.
+func (sd *SignedData) TBS(index int) ([]byte, error) {
+	if index < 0 || index >= len(sd.sd.SignerInfos) {
+		return nil, xerrors.Errorf("pkcs7: signer index %d out of range", index)
 	}
-	return attrs
+	return marshalAttributes(sd.sd.SignerInfos[index].AuthenticatedAttributes)
 }
 
-func (attrs *attributes) ForMarshaling() ([]attribute, error) {
-	sortables := make(attributeSet, len(attrs.types))
-	for i := range sortables {
-		attrType := attrs.types[i]
-		attrValue := attrs.values[i]
-		asn1Value, err := asn1.Marshal(attrValue)
-		if err != nil {
-			return nil, xerrors.Errorf("marshaling value for %v: %w", attrType, err)
-		}
-		attr := attribute{
-			Type:  attrType,
-			Value: asn1.RawValue{Tag: 17, IsCompound: true, Bytes: asn1Value}, // 17 == SET tag
-		}
-		encoded, err := asn1.Marshal(attr)
-		if err != nil {
-			return nil, xerrors.Errorf("marshaling attribute %v: %w", attr, err)
-		}
-		sortables[i] = sortableAttribute{
-			SortKey:   encoded,
-			Attribute: attr,
-		}
+// SetSignature replaces the signature value of the signer at index
+func (sd *SignedData) SetSignature(index int, signature []byte) error {
+	if index < 0 || index >= len(sd.sd.SignerInfos) {
+		return xerrors.Errorf("pkcs7: signer index %d out of range", index)
 	}
-	sort.Sort(sortables)
-	return sortables.Attributes(), nil
+	sd.sd.SignerInfos[index].EncryptedDigest = signature
+	return nil
 }
 
-// AddSigner signs attributes about the content and adds certificate to payload
-func (sd *SignedData) AddSigner(cert *x509.Certificate, pkey crypto.PrivateKey, config SignerInfoConfig) error {
-	attrs := &attributes{}
-	attrs.Add(oidAttributeContentType, sd.sd.ContentInfo.ContentType)
-	attrs.Add(oidAttributeMessageDigest, sd.messageDigest)
-	attrs.Add(oidAttributeSigningTime, time.Now())
-	for _, attr := range config.ExtraSignedAttributes {
-		attrs.Add(attr.Type, attr.Value)
+// RemoveSigningTime drops the signing-time attribute of the signer at index
+// and signs the remaining signed attributes again with pkey, which must be the
+// private key of the signer's certificate. Of config only Rand and
+// DeterministicECDSA apply to the new signature. Removing the attribute invalidates
+// the existing signature, so it can only be done while re-signing: parse the
+// message, turn it into a SignedData with NewSignedDataFrom, call
+// RemoveSigningTime for the signer and Finish. The unsigned attributes are
+// kept as they are, so a timestamp token over the old signature no longer
+// matches and should be removed with PKCS7.RemoveUnsignedAttributes.
+func (sd *SignedData) RemoveSigningTime(index int, pkey crypto.PrivateKey, config SignerInfoConfig) error {
+	if index < 0 || index >= len(sd.sd.SignerInfos) {
+		return xerrors.Errorf("pkcs7: signer index %d out of range", index)
+	}
+	signer := sd.sd.SignerInfos[index]
+	cert := getCertFromCertsByIssuerAndSerial(sd.certs, signer.IssuerAndSerialNumber)
+	if cert == nil {
+		return xerrors.New("pkcs7: no certificate for the signer")
+	}
+	if err := checkSignerKey(cert, pkey); err != nil {
+		return err
 	}
-	finalAttrs, err := attrs.ForMarshaling()
+	if pub, ok := pkey.(crypto.Signer); !ok || !pub.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		return xerrors.New("pkcs7: private key does not match the signer certificate")
+	}
+	if signer.DigestEncryptionAlgorithm.Algorithm.Equal(oidSignatureRSAPSS) {
+		return xerrors.Errorf("pkcs7: re-signing with RSASSA-PSS: %w", ErrUnsupportedAlgorithm)
+	}
+	hash, err := signatureHashOf(signer)
 	if err != nil {
 		return err
 	}
-	signature, err := signAttributes(finalAttrs, pkey, crypto.SHA256)
+	var sortables attributeSet
+	for _, attr := range signer.AuthenticatedAttributes {
+		if attr.Type.Equal(oidAttributeSigningTime) {
+			continue
+		}
+		encoded, err := asn1.Marshal(attr)
+		if err != nil {
+			return xerrors.Errorf("marshaling attribute %v: %w", attr.Type, err)
+		}
+		sortables = append(sortables, sortableAttribute{SortKey: encoded, Attribute: attr})
+	}
+	if len(sortables) == len(signer.AuthenticatedAttributes) {
+		return nil
+	}
+	sort.Sort(sortables)
+	attrs := sortables.Attributes()
+	signature, err := signAttributes(attrs, pkey, hash, config.signatureRandom())
 	if err != nil {
 		return xerrors.Errorf("signing attrs: %w", err)
 	}
+	signer.Raw = nil
+	signer.AuthenticatedAttributes = attrs
+	signer.EncryptedDigest = signature
+	sd.sd.SignerInfos[index] = signer
+	return nil
+}
 
-	ias, err := cert2issuerAndSerial(cert)
-	if err != nil {
-		return err
+// signedAttributes builds the sorted authenticated attributes of a signer
+func (sd *SignedData) signedAttributes(messageDigest []byte, config SignerInfoConfig) ([]attribute, error) {
+	attrs := &attributes{}
+	attrs.Add(oidAttributeContentType, sd.sd.ContentInfo.ContentType)
+	attrs.Add(oidAttributeMessageDigest, messageDigest)
+	if !config.SkipSigningTime {
+		attrs.Add(oidAttributeSigningTime, config.signingTime())
 	}
-
-	signer := signerInfo{
-		AuthenticatedAttributes:   finalAttrs,
-		DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
-		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSA},
-		IssuerAndSerialNumber:     ias,
-		EncryptedDigest:           signature,
-		Version:                   1,
+	for _, attr := range config.ExtraSignedAttributes {
+		attrs.Add(attr.Type, attr.Value)
 	}
-	// create signature of signed attributes
-	sd.certs = append(sd.certs, cert)
-	sd.sd.SignerInfos = append(sd.sd.SignerInfos, signer)
-	sd.pkeys = append(sd.pkeys, pkey)
-	return nil
+	return attrs.ForMarshaling()
 }
 
 // AddCertificate adds the certificate to the payload. Useful for parent certificates
@@ -708,15 +2533,55 @@ func (sd *SignedData) AddCertificate(cert *x509.Certificate) {
 }
 
 // Detach removes content from the signed data struct to make it a detached signature.
-// This must be called right before Finish()
+// This must be called right before Finish(), or before SignFrom() when
+// streaming, in which case the content is digested but not written.
 func (sd *SignedData) Detach() {
 	sd.sd.ContentInfo = contentInfo{ContentType: oidData}
+	sd.detached = true
+}
+
+// Compress replaces the content with a zlib CompressedData as described in
+// RFC 3274, which is then signed in place of the original content. Parse
+// exposes the decompressed content as Content and fails with
+// ErrContentTooLarge beyond 256 MiB, while the streaming decoder writes the
+// CompressedData encoding. It must be called before AddSigner, or
+// before SignFrom when streaming, in which case the content is buffered for
+// compression.
+func (sd *SignedData) Compress() error {
+	if sd.w != nil {
+		sd.compress = true
+		return nil
+	}
+	if sd.detached || len(sd.sd.SignerInfos) > 0 {
+		return xerrors.New("pkcs7: content must be compressed before detaching or adding signers")
+	}
+	data, err := sd.sd.ContentInfo.unwrap()
+	if err != nil {
+		return err
+	}
+	compressed, err := marshalCompressedData(data)
+	if err != nil {
+		return err
+	}
+	content, err := asn1.Marshal(compressed)
+	if err != nil {
+		return err
+	}
+	sd.sd.ContentInfo = contentInfo{
+		ContentType: oidCompressedData,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: content, IsCompound: true},
+	}
+	sd.sd.Version = 3
+	h := crypto.SHA256.New()
+	h.Write(compressed)
+	sd.messageDigest = h.Sum(nil)
+	sd.content = compressed
+	return nil
 }
 
 // Finish marshals the content and its signers
 func (sd *SignedData) Finish() ([]byte, error) {
-	sd.sd.Certificates = marshalCertificates(sd.certs)
-	inner, err := asn1.Marshal(sd.sd)
+	inner, err := sd.marshal()
 	if err != nil {
 		return nil, err
 	}
@@ -727,6 +2592,15 @@ func (sd *SignedData) Finish() ([]byte, error) {
 	return asn1.Marshal(outer)
 }
 
+// marshal encodes the SignedData without the outer ContentInfo wrapper
+func (sd *SignedData) marshal() ([]byte, error) {
+	sd.sd.Certificates = marshalCertificates(sd.certs)
+	if err := sd.sortDigestAlgorithms(); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(sd.sd)
+}
+
 func cert2issuerAndSerial(cert *x509.Certificate) (issuerAndSerial, error) {
 	var ias issuerAndSerial
 	// The issuer RDNSequence has to match exactly the sequence in the certificate
@@ -738,23 +2612,39 @@ func cert2issuerAndSerial(cert *x509.Certificate) (issuerAndSerial, error) {
 }
 
 // signs the DER encoded form of the attributes with the private key
-func signAttributes(attrs []attribute, pkey crypto.PrivateKey, hash crypto.Hash) ([]byte, error) {
+func signAttributes(attrs []attribute, pkey crypto.PrivateKey, hash crypto.Hash, random io.Reader) ([]byte, error) {
 	attrBytes, err := marshalAttributes(attrs)
 	if err != nil {
 		return nil, err
 	}
 	h := hash.New()
 	h.Write(attrBytes)
-	hashed := h.Sum(nil)
+	return signDigest(h.Sum(nil), pkey, hash, random)
+}
+
+// signDigest signs the digest computed with hash with the private key
+func signDigest(hashed []byte, pkey crypto.PrivateKey, hash crypto.Hash, random io.Reader) ([]byte, error) {
 	switch priv := pkey.(type) {
 	case *rsa.PrivateKey:
-		data, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed)
+		data, err := rsa.SignPKCS1v15(random, priv, hash, hashed)
 		if err != nil {
 			return nil, xerrors.Errorf("signing pkcs15: %w", err)
 		}
 		return data, nil
+	case *ecdsa.PrivateKey:
+		data, err := priv.Sign(random, hashed, hash)
+		if err != nil {
+			return nil, xerrors.Errorf("signing ecdsa: %w", err)
+		}
+		return data, nil
+	case crypto.Signer:
+		data, err := priv.Sign(random, hashed, hash)
+		if err != nil {
+			return nil, xerrors.Errorf("signing with %T: %w", priv, err)
+		}
+		return data, nil
 	}
-	return nil, xerrors.Errorf("signing attributes: %w", ErrUnsupportedAlgorithm)
+	return nil, xerrors.Errorf("signing: %w", ErrUnsupportedAlgorithm)
 }
 
 // concats and wraps the certificates in the RawValue structure
@@ -807,16 +2697,25 @@ func DegenerateCertificate(cert []byte) ([]byte, error) {
 const (
 	EncryptionAlgorithmDESCBC = iota
 	EncryptionAlgorithmAES128GCM
+	EncryptionAlgorithmAES128CBC
+	EncryptionAlgorithmAES256CBC
 )
 
 // ContentEncryptionAlgorithm determines the algorithm used to encrypt the
 // plaintext message. Change the value of this variable to change which
 // algorithm is used in the Encrypt() function.
+//
+// Deprecated: mutating the variable is not safe while other goroutines
+// encrypt, use EncryptWithAlgorithm instead.
 var ContentEncryptionAlgorithm = EncryptionAlgorithmDESCBC
 
 // ErrUnsupportedEncryptionAlgorithm is returned when attempting to encrypt
 // content with an unsupported algorithm.
-var ErrUnsupportedEncryptionAlgorithm = xerrors.New("pkcs7: cannot encrypt content: only DES-CBC and AES-128-GCM supported")
+var ErrUnsupportedEncryptionAlgorithm = xerrors.New("pkcs7: cannot encrypt content: only DES-CBC, AES-128-CBC, AES-256-CBC and AES-128-GCM supported")
+
+// ErrDESKeyAgreement is returned when encrypting with DES-CBC to an EC
+// recipient, whose AES key wrap cannot wrap the 8 byte DES key
+var ErrDESKeyAgreement = xerrors.New("pkcs7: DES-CBC cannot be used with EC recipients, choose an AES content encryption algorithm")
 
 const nonceSize = 12
 
@@ -854,51 +2753,81 @@ func encryptAES128GCM(content []byte) ([]byte, *encryptedContentInfo, error) {
 	ciphertext := gcm.Seal(nil, nonce, content, nil)
 
 	// Prepare ASN.1 Encrypted Content Info
-	paramSeq := aesGCMParameters{
-		Nonce:  nonce,
-		ICVLen: gcm.Overhead(),
-	}
-
-	paramBytes, err := asn1.Marshal(paramSeq)
+	alg, err := aesGCMAlgorithm(nonce, gcm.Overhead())
 	if err != nil {
 		return nil, nil, err
 	}
 
 	eci := encryptedContentInfo{
-		ContentType: oidData,
-		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
-			Algorithm: oidEncryptionAlgorithmAES128GCM,
-			Parameters: asn1.RawValue{
-				Tag:   asn1.TagSequence,
-				Bytes: paramBytes,
-			},
-		},
-		EncryptedContent: marshalEncryptedContent(ciphertext),
+		ContentType:                oidData,
+		ContentEncryptionAlgorithm: alg,
+		EncryptedContent:           marshalEncryptedContent(ciphertext),
 	}
 
 	return key, &eci, nil
 }
 
-func encryptDESCBC(content []byte) ([]byte, *encryptedContentInfo, error) {
-	// Create DES key & CBC IV
-	key := make([]byte, 8)
-	iv := make([]byte, des.BlockSize)
+// aesGCMAlgorithm returns the AES-128-GCM content encryption algorithm with
+// its parameters
+func aesGCMAlgorithm(nonce []byte, icvLen int) (pkix.AlgorithmIdentifier, error) {
+	paramBytes, err := asn1.Marshal(aesGCMParameters{
+		Nonce:  nonce,
+		ICVLen: icvLen,
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{
+		Algorithm: oidEncryptionAlgorithmAES128GCM,
+		Parameters: asn1.RawValue{
+			Tag:   asn1.TagSequence,
+			Bytes: paramBytes,
+		},
+	}, nil
+}
+
+func encryptDESCBC(content, iv []byte) ([]byte, *encryptedContentInfo, error) {
+	return encryptCBC(content, iv, oidEncryptionAlgorithmDESCBC, 8, des.NewCipher)
+}
+
+func encryptAES128CBC(content, iv []byte) ([]byte, *encryptedContentInfo, error) {
+	return encryptCBC(content, iv, oidEncryptionAlgorithmAES128CBC, 16, aes.NewCipher)
+}
+
+func encryptAES256CBC(content, iv []byte) ([]byte, *encryptedContentInfo, error) {
+	return encryptCBC(content, iv, oidEncryptionAlgorithmAES256CBC, 32, aes.NewCipher)
+}
+
+// encryptCBC encrypts content with a random key and iv, or a random IV when
+// iv is nil
+func encryptCBC(content, iv []byte, alg asn1.ObjectIdentifier, keyLen int, newCipher func([]byte) (cipher.Block, error)) ([]byte, *encryptedContentInfo, error) {
+	// Create key
+	key := make([]byte, keyLen)
 	_, err := rand.Read(key)
 	if err != nil {
 		return nil, nil, err
 	}
-	_, err = rand.Read(iv)
+	block, err := newCipher(key)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// Create CBC IV
+	if iv == nil {
+		iv = make([]byte, block.BlockSize())
+		if _, err = rand.Read(iv); err != nil {
+			return nil, nil, err
+		}
+	} else if len(iv) != block.BlockSize() {
+		return nil, nil, xerrors.Errorf("pkcs7: IV must be %d bytes, got %d", block.BlockSize(), len(iv))
+	}
+
 	// Encrypt padded content
-	block, err := des.NewCipher(key)
+	mode := cipher.NewCBCEncrypter(block, iv)
+	plaintext, err := pad(content, mode.BlockSize())
 	if err != nil {
 		return nil, nil, err
 	}
-	mode := cipher.NewCBCEncrypter(block, iv)
-	plaintext, err := pad(content, mode.BlockSize())
 	cyphertext := make([]byte, len(plaintext))
 	mode.CryptBlocks(cyphertext, plaintext)
 
@@ -906,7 +2835,7 @@ func encryptDESCBC(content []byte) ([]byte, *encryptedContentInfo, error) {
 	eci := encryptedContentInfo{
 		ContentType: oidData,
 		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
-			Algorithm:  oidEncryptionAlgorithmDESCBC,
+			Algorithm:  alg,
 			Parameters: asn1.RawValue{Tag: 4, Bytes: iv},
 		},
 		EncryptedContent: marshalEncryptedContent(cyphertext),
@@ -925,68 +2854,235 @@ func encryptDESCBC(content []byte) ([]byte, *encryptedContentInfo, error) {
 //
 //     ContentEncryptionAlgorithm = EncryptionAlgorithmAES128GCM
 //
-// TODO(fullsailor): Add support for encrypting content with other algorithms
+// Encrypt is not safe to call concurrently with changes of
+// ContentEncryptionAlgorithm, use EncryptWithAlgorithm for that.
 func Encrypt(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	return EncryptWithAlgorithm(content, recipients, ContentEncryptionAlgorithm)
+}
+
+// EncryptWithAlgorithm creates and returns an envelope data PKCS7 structure
+// with encrypted recipient keys for each recipient public key, encrypting the
+// content with the given algorithm, e.g. EncryptionAlgorithmAES128GCM.
+func EncryptWithAlgorithm(content []byte, recipients []*x509.Certificate, algorithm int) ([]byte, error) {
+	return encrypt(content, oidData, recipients, algorithm, EnvelopeConfig{})
+}
+
+// EncryptWithOriginator is like EncryptWithAlgorithm but also carries the
+// originator certificates in the originatorInfo of the envelope, e.g. the
+// sender certificate for key agreement recipients. Parse exposes them as
+// Certificates of the parsed message.
+func EncryptWithOriginator(content []byte, recipients []*x509.Certificate, algorithm int, originator []*x509.Certificate) ([]byte, error) {
+	return EncryptWithConfig(content, recipients, algorithm, EnvelopeConfig{Originator: originator})
+}
+
+// EnvelopeConfig are optional values to include in an EnvelopedData
+type EnvelopeConfig struct {
+	// Originator certificates are carried in the originatorInfo
+	Originator []*x509.Certificate
+	// UnprotectedAttributes are carried in the clear next to the encrypted
+	// content, e.g. as key identification hints
+	UnprotectedAttributes []Attribute
+	// OAEP, when set, makes RSA recipients use RSAES-OAEP key transport
+	OAEP *OAEPConfig
+	// AuthenticatedAttributes, e.g. a caller value bound to the content, turn
+	// the message into an AuthEnvelopedData (RFC 5083) whose authAttrs carry
+	// them along with the content-type attribute. AES-GCM authenticates their
+	// DER with the content, so the recipient reads them back with
+	// PKCS7.AuthenticatedAttributes once Decrypt succeeds. They require
+	// EncryptionAlgorithmAES128GCM.
+	AuthenticatedAttributes []Attribute
+	// IV replaces the random IV of the CBC content encryption algorithms, e.g.
+	// for reproducible tests or protocols that dictate it. It must be as long
+	// as the cipher block. AES-GCM does not accept it.
+	IV []byte
+}
+
+// EncryptWithConfig is like EncryptWithAlgorithm but also includes the
+// optional values of config in the envelope
+func EncryptWithConfig(content []byte, recipients []*x509.Certificate, algorithm int, config EnvelopeConfig) ([]byte, error) {
+	return encrypt(content, oidData, recipients, algorithm, config)
+}
+
+func encrypt(content []byte, contentType asn1.ObjectIdentifier, recipients []*x509.Certificate, algorithm int, config EnvelopeConfig) ([]byte, error) {
 	var eci *encryptedContentInfo
 	var key []byte
 	var err error
 
+	if algorithm == EncryptionAlgorithmDESCBC {
+		for _, recipient := range recipients {
+			if _, ok := recipient.PublicKey.(*ecdsa.PublicKey); ok {
+				return nil, ErrDESKeyAgreement
+			}
+		}
+	}
+	if len(config.AuthenticatedAttributes) > 0 {
+		if algorithm != EncryptionAlgorithmAES128GCM {
+			return nil, xerrors.Errorf("pkcs7: authenticated attributes require AES-GCM: %w", ErrUnsupportedEncryptionAlgorithm)
+		}
+		return encryptAuthEnveloped(content, contentType, recipients, config)
+	}
+
 	// Apply chosen symmetric encryption method
-	switch ContentEncryptionAlgorithm {
+	switch algorithm {
 	case EncryptionAlgorithmDESCBC:
-		key, eci, err = encryptDESCBC(content)
+		key, eci, err = encryptDESCBC(content, config.IV)
 
 	case EncryptionAlgorithmAES128GCM:
+		if config.IV != nil {
+			return nil, xerrors.New("pkcs7: caller supplied IV is not supported for AES-GCM, reusing a nonce breaks it")
+		}
 		key, eci, err = encryptAES128GCM(content)
 
+	case EncryptionAlgorithmAES128CBC:
+		key, eci, err = encryptAES128CBC(content, config.IV)
+
+	case EncryptionAlgorithmAES256CBC:
+		key, eci, err = encryptAES256CBC(content, config.IV)
+
 	default:
 		return nil, ErrUnsupportedEncryptionAlgorithm
 	}
 
+	if err != nil {
+		return nil, err
+	}
+	eci.ContentType = contentType
+	envelope, err := newEnvelope(*eci, key, recipients, config)
+	if err != nil {
+		return nil, err
+	}
+	innerContent, err := asn1.Marshal(envelope)
 	if err != nil {
 		return nil, err
 	}
 
+	// Prepare outer payload structure
+	wrapper := contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: innerContent},
+	}
+
+	return asn1.Marshal(wrapper)
+}
+
+// newEnvelope encrypts the content encryption key for every recipient and
+// returns the EnvelopedData around eci
+func newEnvelope(eci encryptedContentInfo, key []byte, recipients []*x509.Certificate, config EnvelopeConfig) (envelopedData, error) {
+	var err error
 	// Prepare each recipient's encrypted cipher key
-	recipientInfos := make([]recipientInfo, len(recipients))
+	version := 0
+	recipientInfos := make([]asn1.RawValue, len(recipients))
 	for i, recipient := range recipients {
-		encrypted, err := encryptKey(key, recipient)
+		if pub, ok := recipient.PublicKey.(*ecdsa.PublicKey); ok {
+			// key agreement recipients require version 2
+			version = 2
+			if recipientInfos[i], err = encryptKeyAgree(key, recipient, pub); err != nil {
+				return envelopedData{}, err
+			}
+			continue
+		}
+		keyAlgorithm := pkix.AlgorithmIdentifier{Algorithm: oidRSA}
+		var encrypted []byte
+		if config.OAEP != nil {
+			if keyAlgorithm, err = config.OAEP.algorithm(); err != nil {
+				return envelopedData{}, err
+			}
+			pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+			if !ok {
+				return envelopedData{}, ErrUnsupportedAlgorithm
+			}
+			encrypted, err = config.OAEP.encryptKey(key, pub)
+		} else {
+			encrypted, err = encryptKey(key, recipient)
+		}
 		if err != nil {
-			return nil, err
+			return envelopedData{}, err
 		}
 		ias, err := cert2issuerAndSerial(recipient)
 		if err != nil {
-			return nil, err
+			return envelopedData{}, err
 		}
 		info := recipientInfo{
-			Version:               0,
-			IssuerAndSerialNumber: ias,
-			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
-				Algorithm: oidRSA,
-			},
-			EncryptedKey: encrypted,
+			Version:                0,
+			IssuerAndSerialNumber:  ias,
+			KeyEncryptionAlgorithm: keyAlgorithm,
+			EncryptedKey:           encrypted,
+		}
+		data, err := asn1.Marshal(info)
+		if err != nil {
+			return envelopedData{}, err
 		}
-		recipientInfos[i] = info
+		recipientInfos[i] = asn1.RawValue{FullBytes: data}
 	}
 
 	// Prepare envelope content
 	envelope := envelopedData{
-		EncryptedContentInfo: *eci,
-		Version:              0,
+		EncryptedContentInfo: eci,
+		Version:              version,
 		RecipientInfos:       recipientInfos,
 	}
-	innerContent, err := asn1.Marshal(envelope)
+	if len(config.Originator) > 0 {
+		// originatorInfo requires version 2
+		envelope.Version = 2
+		envelope.OriginatorInfo.Certificates = marshalCertificates(config.Originator)
+	}
+	if len(config.UnprotectedAttributes) > 0 {
+		// so do unprotected attributes
+		envelope.Version = 2
+		var attrs attributes
+		for _, attr := range config.UnprotectedAttributes {
+			attrs.Add(attr.Type, attr.Value)
+		}
+		if envelope.UnprotectedAttrs, err = attrs.ForMarshaling(); err != nil {
+			return envelopedData{}, err
+		}
+	}
+	return envelope, nil
+}
+
+// SignAndEncrypt signs content with the signer certificate and key and
+// envelopes the resulting SignedData for recipients. The inner SignedData is
+// carried as the encrypted content with the id-signedData content type. The
+// content is encrypted with AES-256-CBC, which both RSA and EC recipients
+// support, regardless of ContentEncryptionAlgorithm; use NewSignedData and
+// EncryptWithConfig to choose another algorithm.
+func SignAndEncrypt(content []byte, signerCert *x509.Certificate, signerKey crypto.PrivateKey, recipients []*x509.Certificate) ([]byte, error) {
+	sd, err := NewSignedData(content)
 	if err != nil {
 		return nil, err
 	}
-
-	// Prepare outer payload structure
-	wrapper := contentInfo{
-		ContentType: oidEnvelopedData,
-		Content:     asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: innerContent},
+	if err = sd.AddSigner(signerCert, signerKey, SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	inner, err := sd.marshal()
+	if err != nil {
+		return nil, err
 	}
+	return encrypt(inner, oidSignedData, recipients, EncryptionAlgorithmAES256CBC, EnvelopeConfig{})
+}
 
-	return asn1.Marshal(wrapper)
+// DecryptAndVerify decrypts a message produced by SignAndEncrypt and verifies
+// the enveloped SignedData. The verified inner message is returned.
+func (p7 *PKCS7) DecryptAndVerify(cert *x509.Certificate, pk crypto.PrivateKey) (*PKCS7, error) {
+	data, ok := p7.raw.(envelopedData)
+	if !ok {
+		return nil, ErrNotEncryptedContent
+	}
+	if !data.EncryptedContentInfo.ContentType.Equal(oidSignedData) {
+		return nil, xerrors.Errorf("pkcs7: enveloped content type is %v, expected signed data", data.EncryptedContentInfo.ContentType)
+	}
+	plaintext, err := p7.Decrypt(cert, pk)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := parseSignedData(plaintext)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing enveloped signed data: %w", err)
+	}
+	if err = inner.Verify(); err != nil {
+		return nil, err
+	}
+	return inner, nil
 }
 
 func marshalEncryptedContent(content []byte) asn1.RawValue {
@@ -995,7 +3091,7 @@ func marshalEncryptedContent(content []byte) asn1.RawValue {
 }
 
 func encryptKey(key []byte, recipient *x509.Certificate) ([]byte, error) {
-	if pub := recipient.PublicKey.(*rsa.PublicKey); pub != nil {
+	if pub, ok := recipient.PublicKey.(*rsa.PublicKey); ok {
 		return rsa.EncryptPKCS1v15(rand.Reader, pub, key)
 	}
 	return nil, ErrUnsupportedAlgorithm
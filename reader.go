@@ -12,7 +12,8 @@ import (
 
 type berReader struct {
 	*bufio.Reader
-	bytesRead int
+	bytesRead  int
+	indefinite bool
 }
 
 func newBerReader(r io.Reader) *berReader {
@@ -38,6 +39,7 @@ func (br *berReader) Read(dest []byte) (n int, err error) {
 type continuation func(class int, constructed bool, tag int, length int) error
 
 func (br *berReader) readBER(cont continuation) (rErr error) {
+	offset := br.bytesRead
 	b, err := br.ReadByte()
 	if err != nil {
 		return err
@@ -63,6 +65,7 @@ func (br *berReader) readBER(cont continuation) (rErr error) {
 		return err
 	case b == 0x80:
 		length = -1 // indefinite
+		br.indefinite = true
 	case b < 0x80:
 		length = int(b)
 	default:
@@ -70,9 +73,15 @@ func (br *berReader) readBER(cont continuation) (rErr error) {
 			if b, err = br.ReadByte(); err != nil {
 				return err
 			}
-			length = length*256 + int(b)
+			var ok bool
+			if length, ok = accumulateLength(length, b); !ok {
+				return xerrors.Errorf("length overflows int (offset %d)", offset)
+			}
 		}
 	}
+	if length < 0 && !constructed {
+		return xerrors.Errorf("Indefinite form tag must have constructed encoding (offset %d)", offset)
+	}
 	return cont(class, constructed, tag, length)
 }
 
@@ -190,7 +199,7 @@ func (br *berReader) raw(expected int, optional bool, process func([]byte) error
 
 func (br *berReader) _raw(expected int, optional bool, process func([]byte) error) continuation {
 	return func(class int, constructed bool, tag int, length int) (err error) {
-		if expected >= 0 && tag != expected {
+		if tag == tagAbsent || expected >= 0 && tag != expected {
 			if !optional {
 				return xerrors.Errorf("expected tag %d got %d", expected, tag)
 			}
@@ -241,7 +250,7 @@ func (br *berReader) _octets(next continuation) continuation {
 			}
 			return next(class, constructed, tag, length)
 		}
-		return xerrors.Errorf("expected tag 4 got %d: %w", tag)
+		return xerrors.Errorf("expected tag 4 got %d", tag)
 	}
 }
 
@@ -259,7 +268,14 @@ func (br *berReader) oid(oid asn1.ObjectIdentifier, next continuation) continuat
 		}, next)
 }
 
-func (br *berReader) combine(conts ...continuation) continuation {
+// tagAbsent is passed to the continuations left when a definite-length
+// constructed object ends, they fail unless their object is optional
+const tagAbsent = -1
+
+// combine applies conts to the consecutive objects of a constructed object
+// ending at the offset end, or terminated by end octets if end is negative.
+// The continuations left when the end is reached must all be optional.
+func (br *berReader) combine(end int, conts ...continuation) continuation {
 	return func(class int, constructed bool, tag int, length int) (err error) {
 		n := len(conts) - 1
 		for i, cont := range conts {
@@ -270,12 +286,25 @@ func (br *berReader) combine(conts ...continuation) continuation {
 			} else if err != nil || i == n {
 				return
 			}
-			return br.readBER(br.combine(conts[i+1:]...))
+			if end >= 0 && br.bytesRead >= end {
+				return br.absent(conts[i+1:])
+			}
+			return br.readBER(br.combine(end, conts[i+1:]...))
 		}
 		return nil
 	}
 }
 
+// absent checks that the objects of conts may be missing
+func (br *berReader) absent(conts []continuation) error {
+	for _, cont := range conts {
+		if err := cont(0, false, tagAbsent, 0); !xerrors.Is(err, errConditionNotMet) {
+			return xerrors.Errorf("constructed object ends before a required field: %w", err)
+		}
+	}
+	return nil
+}
+
 func (br *berReader) sequence(conts ...continuation) continuation {
 	return func(class int, constructed bool, tag int, length int) (err error) {
 		if err := br._sequence(conts...)(class, constructed, tag, length); err != nil {
@@ -287,13 +316,18 @@ func (br *berReader) sequence(conts ...continuation) continuation {
 
 func (br *berReader) _sequence(conts ...continuation) continuation {
 	return func(class int, constructed bool, tag int, length int) (err error) {
-		if length < 0 {
-			conts = append(conts, br.endOctets())
-		}
 		if !constructed {
 			return xerrors.Errorf("expected constructed object, got tag %d", tag)
 		}
-		if err := br.readBER(br.combine(conts...)); err != nil {
+		end := -1
+		if length < 0 {
+			conts = append(conts, br.endOctets())
+		} else if length == 0 {
+			return br.absent(conts)
+		} else {
+			end = br.bytesRead + length
+		}
+		if err := br.readBER(br.combine(end, conts...)); err != nil {
 			return err
 		}
 		return nil
@@ -0,0 +1,121 @@
+package pkcs7
+
+import (
+	"encoding/asn1"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	oidSCEPMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidSCEPPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidSCEPFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSCEPSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidSCEPRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidSCEPTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+)
+
+// Values of SCEPAttributes.MessageType, see RFC 8894 section 3.2.1.2
+const (
+	SCEPCertRep    = "3"
+	SCEPRenewalReq = "17"
+	SCEPPKCSReq    = "19"
+	SCEPCertPoll   = "20"
+	SCEPGetCert    = "21"
+	SCEPGetCRL     = "22"
+)
+
+// Values of SCEPAttributes.PKIStatus
+const (
+	SCEPSuccess = "0"
+	SCEPFailure = "2"
+	SCEPPending = "3"
+)
+
+// Values of SCEPAttributes.FailInfo
+const (
+	SCEPBadAlg          = "0"
+	SCEPBadMessageCheck = "1"
+	SCEPBadRequest      = "2"
+	SCEPBadTime         = "3"
+	SCEPBadCertID       = "4"
+)
+
+// SCEPAttributes are the signed attributes of a SCEP pkiMessage of RFC 8894.
+// Empty fields are left out of the signed attributes.
+type SCEPAttributes struct {
+	TransactionID string
+	// MessageType is one of the SCEP message type constants, e.g. SCEPPKCSReq
+	MessageType    string
+	SenderNonce    []byte
+	RecipientNonce []byte
+	// PKIStatus and FailInfo are only present in CertRep messages
+	PKIStatus string
+	FailInfo  string
+}
+
+// Attributes returns the SCEP attributes as signed attributes to be included
+// with SignerInfoConfig.ExtraSignedAttributes
+func (a SCEPAttributes) Attributes() ([]Attribute, error) {
+	if a.TransactionID == "" || a.MessageType == "" {
+		return nil, xerrors.New("pkcs7: SCEP attributes need transactionID and messageType")
+	}
+	attrs := []Attribute{
+		{Type: oidSCEPTransactionID, Value: a.TransactionID},
+		{Type: oidSCEPMessageType, Value: a.MessageType},
+	}
+	if a.PKIStatus != "" {
+		attrs = append(attrs, Attribute{Type: oidSCEPPKIStatus, Value: a.PKIStatus})
+	}
+	if a.FailInfo != "" {
+		attrs = append(attrs, Attribute{Type: oidSCEPFailInfo, Value: a.FailInfo})
+	}
+	if len(a.SenderNonce) > 0 {
+		attrs = append(attrs, Attribute{Type: oidSCEPSenderNonce, Value: a.SenderNonce})
+	}
+	if len(a.RecipientNonce) > 0 {
+		attrs = append(attrs, Attribute{Type: oidSCEPRecipientNonce, Value: a.RecipientNonce})
+	}
+	return attrs, nil
+}
+
+// SCEPAttributes returns the SCEP attributes among the signed attributes of
+// the signer at index
+func (p7 *PKCS7) SCEPAttributes(index int) (*SCEPAttributes, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return nil, err
+	}
+	var a SCEPAttributes
+	for _, field := range []struct {
+		oid asn1.ObjectIdentifier
+		out interface{}
+	}{
+		{oidSCEPTransactionID, &a.TransactionID},
+		{oidSCEPMessageType, &a.MessageType},
+		{oidSCEPPKIStatus, &a.PKIStatus},
+		{oidSCEPFailInfo, &a.FailInfo},
+		{oidSCEPSenderNonce, &a.SenderNonce},
+		{oidSCEPRecipientNonce, &a.RecipientNonce},
+	} {
+		if !hasAttribute(signer.AuthenticatedAttributes, field.oid) {
+			continue
+		}
+		if err = unmarshalAttribute(signer.AuthenticatedAttributes, field.oid, field.out); err != nil {
+			return nil, xerrors.Errorf("pkcs7: SCEP attribute %v: %w", field.oid, err)
+		}
+	}
+	if a.MessageType == "" {
+		return nil, xerrors.New("pkcs7: signer has no SCEP messageType attribute")
+	}
+	return &a, nil
+}
+
+func hasAttribute(attrs []attribute, attributeType asn1.ObjectIdentifier) bool {
+	for _, attr := range attrs {
+		if attr.Type.Equal(attributeType) {
+			return true
+		}
+	}
+	return false
+}
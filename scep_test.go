@@ -0,0 +1,66 @@
+package pkcs7
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestSCEPAttributes(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SCEPAttributes{
+		TransactionID:  "2F3C88E1A0B54B1E",
+		MessageType:    SCEPCertRep,
+		SenderNonce:    []byte("sender-nonce-123"),
+		RecipientNonce: []byte("recipient-nonce1"),
+		PKIStatus:      SCEPFailure,
+		FailInfo:       SCEPBadRequest,
+	}
+	attrs, err := want.Attributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned, err := NewSignedData([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{ExtraSignedAttributes: attrs}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p7.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p7.SCEPAttributes(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TransactionID != want.TransactionID || got.MessageType != want.MessageType ||
+		got.PKIStatus != want.PKIStatus || got.FailInfo != want.FailInfo ||
+		!bytes.Equal(got.SenderNonce, want.SenderNonce) || !bytes.Equal(got.RecipientNonce, want.RecipientNonce) {
+		t.Errorf("expected %+v, got %+v", want, *got)
+	}
+	signedAttrs, err := p7.SignedAttributes(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range signedAttrs {
+		if attr.Type.Equal(oidSCEPTransactionID) && attr.Values[0].Tag != asn1.TagPrintableString {
+			t.Errorf("expected transactionID as PrintableString, got tag %d", attr.Values[0].Tag)
+		}
+	}
+
+	if _, err = (SCEPAttributes{MessageType: SCEPPKCSReq}).Attributes(); err == nil {
+		t.Error("expected error for missing transactionID")
+	}
+}
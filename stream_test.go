@@ -1,15 +1,27 @@
 package pkcs7
 
 import (
+	"bufio"
 	"bytes"
+	"crypto"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+
+	"golang.org/x/xerrors"
 )
 
 func TestDecoder_VerifyTo(t *testing.T) {
@@ -45,6 +57,276 @@ func TestDecoder_VerifyTo(t *testing.T) {
 	}
 }
 
+func TestDecoder_VerifyToIndefinitePrimitive(t *testing.T) {
+	// SignedData whose version INTEGER (at offset 17) claims indefinite length
+	ber := []byte{
+		0x30, 0x80,
+		0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x02,
+		0xa0, 0x80,
+		0x30, 0x80,
+		0x02, 0x80, 0x01, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	const expected = "Indefinite form tag must have constructed encoding (offset 17)"
+	err := NewDecoder(bytes.NewReader(ber)).VerifyTo(ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("VerifyTo: expected error %q, got %v", expected, err)
+	}
+	_, err = ber2der(ber)
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("ber2der: expected error %q, got %v", expected, err)
+	}
+}
+
+func TestDecoder_VerifyToErrors(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signed := buf.Bytes()
+
+	tampered := bytes.Replace(signed, content, []byte("Hello Wörld"[:len(content)]), 1)
+	var mismatch *MessageDigestMismatchError
+	if err = NewDecoder(bytes.NewReader(tampered)).VerifyTo(ioutil.Discard); !xerrors.As(err, &mismatch) {
+		t.Errorf("expected message digest mismatch for tampered content, got %v", err)
+	}
+
+	signature := toBeSigned.sd.SignerInfos[0].EncryptedDigest
+	forged := append([]byte{}, signature...)
+	forged[0] ^= 0xff
+	tampered = bytes.Replace(signed, signature, forged, 1)
+	var invalid *InvalidSignatureError
+	if err = NewDecoder(bytes.NewReader(tampered)).VerifyTo(ioutil.Discard); !xerrors.As(err, &invalid) {
+		t.Errorf("expected invalid signature for tampered signature, got %v", err)
+	}
+
+	overflow := []byte{0x30, 0x89, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}
+	if err = NewDecoder(bytes.NewReader(overflow)).VerifyTo(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "overflows int") {
+		t.Errorf("expected length overflow error, got %v", err)
+	}
+}
+
+func TestDecoder_VerifyToLimited(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("Hello World "), 1000)
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signed := buf.Bytes()
+
+	out := new(bytes.Buffer)
+	if err = NewDecoder(bytes.NewReader(signed)).VerifyToLimited(out, int64(len(content))); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("content mismatch")
+	}
+
+	out.Reset()
+	limit := int64(len(content) - 1)
+	if err = NewDecoder(bytes.NewReader(signed)).VerifyToLimited(out, limit); !xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected ErrContentTooLarge, got %v", err)
+	}
+	if int64(out.Len()) > limit {
+		t.Errorf("%d bytes written past limit %d", out.Len(), limit)
+	}
+
+	out.Reset()
+	p7 := NewDecoder(bytes.NewReader(signed))
+	p7.MaxContentSize = limit
+	if err = p7.VerifyToLimited(out, int64(len(content))); !xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected the smaller MaxContentSize to apply, got %v", err)
+	}
+	if p7.MaxContentSize != limit {
+		t.Errorf("MaxContentSize changed to %d", p7.MaxContentSize)
+	}
+}
+
+func TestDecoder_ProgressAndLimit(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("Hello World "), 10000)
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signed := buf.Bytes()
+
+	var calls int
+	var last int64
+	p7 := NewDecoder(bytes.NewReader(signed))
+	p7.MaxContentSize = int64(len(content))
+	p7.OnProgress = func(read int64) {
+		if read <= last {
+			t.Errorf("progress went from %d to %d", last, read)
+		}
+		calls++
+		last = read
+	}
+	out := new(bytes.Buffer)
+	if err = p7.VerifyTo(out); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("content mismatch")
+	}
+	if calls == 0 || last != int64(len(content)) {
+		t.Errorf("expected progress up to %d bytes, got %d calls up to %d", len(content), calls, last)
+	}
+	if p7.ContentRead() != int64(len(content)) {
+		t.Errorf("expected %d bytes read, got %d", len(content), p7.ContentRead())
+	}
+
+	out.Reset()
+	limit := int64(len(content) - 1)
+	p7 = NewDecoder(bytes.NewReader(signed))
+	p7.MaxContentSize = limit
+	if err = p7.VerifyTo(out); !xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected ErrContentTooLarge, got %v", err)
+	}
+	if int64(out.Len()) > limit || p7.ContentRead() > limit {
+		t.Errorf("%d bytes written and %d read past limit %d", out.Len(), p7.ContentRead(), limit)
+	}
+
+	// the external content is counted and limited the same way
+	buf.Reset()
+	toBeSigned = NewEncoder(buf)
+	if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	toBeSigned.Detach()
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	p7 = NewDecoder(bytes.NewReader(buf.Bytes()))
+	calls = 0
+	p7.OnProgress = func(int64) { calls++ }
+	if err = p7.VerifyExternalTo(ioutil.Discard, bytes.NewReader(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if calls == 0 || p7.ContentRead() != int64(len(content)) {
+		t.Errorf("expected progress over %d external bytes, got %d calls and %d bytes", len(content), calls, p7.ContentRead())
+	}
+	p7 = NewDecoder(bytes.NewReader(buf.Bytes()))
+	p7.MaxContentSize = limit
+	if err = p7.VerifyExternalTo(ioutil.Discard, bytes.NewReader(content)); !xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected ErrContentTooLarge for external content, got %v", err)
+	}
+}
+
+func TestDecoder_VerifyExternalTo(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signed := buf.Bytes()
+
+	dest := new(bytes.Buffer)
+	if err = NewDecoder(bytes.NewReader(signed)).VerifyExternalTo(dest, bytes.NewReader(content)); err != nil {
+		t.Errorf("%+v", err)
+	}
+	if !bytes.Equal(content, dest.Bytes()) {
+		t.Error("content does not match")
+	}
+	err = NewDecoder(bytes.NewReader(signed)).VerifyExternalTo(ioutil.Discard, strings.NewReader("Hello Earth"))
+	if err != ErrContentMismatch {
+		t.Errorf("expected content mismatch error, got %v", err)
+	}
+}
+
+// crlfWriter converts bare LF line endings into CRLF
+type crlfWriter struct {
+	w  io.Writer
+	cr bool
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b == '\n' && !c.cr {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+		c.cr = b == '\r'
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func TestDecoder_VerifyToCanonicalize(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned, err := NewSignedData([]byte("line one\r\nline two\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	// the transport replaced line endings after signing
+	transported := []byte("line one\nline two\n")
+	content, err := asn1.Marshal(transported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned.sd.ContentInfo.Content = asn1.RawValue{Class: 2, Tag: 0, Bytes: content, IsCompound: true}
+	signed, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = NewDecoder(bytes.NewReader(signed)).VerifyTo(ioutil.Discard); err == nil {
+		t.Fatal("expected verification to fail without canonicalization")
+	}
+	p7 := NewDecoder(bytes.NewReader(signed))
+	p7.Canonicalize = func(w io.Writer) io.Writer {
+		return &crlfWriter{w: w}
+	}
+	dest := new(bytes.Buffer)
+	if err = p7.VerifyTo(dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(transported, dest.Bytes()) {
+		t.Errorf("expected content %q, got %q", transported, dest.Bytes())
+	}
+}
+
 func BenchmarkVerifyTo(b *testing.B) {
 	fixture := UnmarshalTestFixture(SignedTestFixture)
 	b.ResetTimer()
@@ -125,38 +407,1067 @@ func BenchmarkSignFrom(b *testing.B) {
 	}
 }
 
-func TestVerifyData(t *testing.T) {
-	_, err := os.Stat("testdata")
+// benchmarkDualDigest signs with a SHA-256 and a SHA-512 signer, so that two
+// hashes are computed over the content
+func benchmarkDualDigest(b *testing.B, parallel bool) {
+	cert, err := createTestCertificate()
 	if err != nil {
-		t.Skip("checking testdata: ", err)
+		b.Fatal(err)
 	}
-	if err = filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
-		if !strings.HasSuffix(path, ".cms") || info.IsDir() || err != nil {
-			return err
+	b.SetBytes(content.Size())
+	for i := 0; i < b.N; i++ {
+		toBeSigned := NewEncoder(ioutil.Discard)
+		for j := 0; j < 2; j++ {
+			if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+				b.Fatalf("Cannot add signer: %s", err)
+			}
+		}
+		toBeSigned.sd.SignerInfos[1].DigestAlgorithm.Algorithm = oidSHA512
+		if parallel {
+			err = toBeSigned.SignFromReaderAt(content, int(content.Size()))
+		} else {
+			err = toBeSigned.SignFrom(content, int(content.Size()))
 		}
-		fp, err := os.Open(path)
 		if err != nil {
-			return err
+			b.Fatalf("Cannot finish signing data: %s", err)
 		}
-		defer fp.Close()
-		t.Run(filepath.Base(path), func(t *testing.T) {
-			p7 := NewDecoder(fp)
-			buf := new(bytes.Buffer)
-			if err = p7.VerifyTo(buf); err != nil {
-				t.Errorf("%+v", err)
-				return
-			}
-			signer := p7.GetOnlySigner()
-			if signer == nil || len(signer.Raw) == 0 {
-				t.Errorf("no signer for %s", path)
-				return
+		content.Seek(0, 0)
+	}
+}
+
+func BenchmarkSignFromDualDigest(b *testing.B) {
+	benchmarkDualDigest(b, false)
+}
+
+func BenchmarkSignFromReaderAtDualDigest(b *testing.B) {
+	benchmarkDualDigest(b, true)
+}
+
+func BenchmarkSignFromSmall(b *testing.B) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		b.Fatal(err)
+	}
+	small := make([]byte, 256)
+	b.SetBytes(int64(len(small)))
+	for i := 0; i < b.N; i++ {
+		toBeSigned := NewEncoder(ioutil.Discard)
+		if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+			b.Fatalf("Cannot add signer: %s", err)
+		}
+		if err = toBeSigned.SignFrom(bytes.NewReader(small), len(small)); err != nil {
+			b.Fatalf("Cannot finish signing data: %s", err)
+		}
+	}
+}
+
+func TestEncoder_SignFromSmall(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("eyJhbGciOiJub25lIn0.eyJzdWIiOiJ0b2tlbiJ9")
+	for _, detached := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		toBeSigned := NewEncoder(buf)
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if detached {
+			toBeSigned.Detach()
+		}
+		estimate, err := toBeSigned.EstimatedSize(len(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+			t.Fatal(err)
+		}
+		if estimate != int64(buf.Len()) {
+			t.Errorf("detached %v: estimated %d bytes, wrote %d", detached, estimate, buf.Len())
+		}
+		if !isDER(buf.Bytes()) {
+			t.Fatalf("detached %v: expected DER output for small content", detached)
+		}
+		p7, err := Parse(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detached {
+			p7.Content = content
+		} else if !bytes.Equal(p7.Content, content) {
+			t.Errorf("expected content %q, got %q", content, p7.Content)
+		}
+		if err = p7.Verify(); err != nil {
+			t.Errorf("detached %v: %v", detached, err)
+		}
+	}
+
+	toBeSigned := NewEncoder(ioutil.Discard)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)+1); err == nil {
+		t.Error("expected an error for content shorter than its size")
+	}
+
+	buf := new(bytes.Buffer)
+	large := make([]byte, smallContentSize+1)
+	toBeSigned = NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(large), len(large)); err != nil {
+		t.Fatal(err)
+	}
+	if isDER(buf.Bytes()) {
+		t.Error("expected content above the threshold to be streamed")
+	}
+}
+
+func TestEncoder_NoAttributes(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert, ecKey, err := createTestECCertificate(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one content streamed and one below smallContentSize
+	for _, content := range [][]byte{bytes.Repeat([]byte("Hello World"), 1000), []byte("Hello World")} {
+		buf := new(bytes.Buffer)
+		toBeSigned := NewEncoder(buf)
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{NoAttributes: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err = toBeSigned.AddSigner(ecCert, ecKey, SignerInfoConfig{NoAttributes: true, DigestAlgorithm: crypto.SHA384}); err != nil {
+			t.Fatal(err)
+		}
+		estimate, err := toBeSigned.EstimatedSize(len(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+			t.Fatal(err)
+		}
+		if actual := int64(buf.Len()); actual > estimate || estimate-actual > 8 {
+			t.Errorf("estimated %d bytes, wrote %d", estimate, actual)
+		}
+		out := new(bytes.Buffer)
+		if err = NewDecoder(bytes.NewReader(buf.Bytes())).VerifyTo(out); err != nil {
+			t.Fatalf("VerifyTo: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Error("streamed content does not match")
+		}
+		p7, err := Parse(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, signer := range p7.Signers {
+			if len(signer.AuthenticatedAttributes) > 0 {
+				t.Errorf("signer %d: expected no signed attributes", i)
 			}
-			fn := strings.TrimSuffix(filepath.Base(path), ".cms")
-			t.Logf("verify success on %s", fn)
-			ioutil.WriteFile(filepath.Join("fixtures", fn+".bin"), buf.Bytes(), os.ModePerm)
-		})
-		return nil
-	}); err != nil {
-		t.Errorf("%+v", err)
+		}
+		if err = p7.Verify(); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		p7.Content = append([]byte("tampered "), p7.Content...)
+		if err = p7.Verify(); err == nil {
+			t.Error("expected tampered content to fail verification")
+		}
+	}
+
+	// the buffered path signs the same way
+	buffered, err := NewSignedData([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = buffered.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{NoAttributes: true}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := buffered.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Signers[0].AuthenticatedAttributes) > 0 {
+		t.Error("expected no signed attributes")
+	}
+	if err = p7.Verify(); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	toBeSigned := NewEncoder(ioutil.Discard)
+	config := SignerInfoConfig{NoAttributes: true, ExtraSignedAttributes: []Attribute{{Type: oidAttributeSigningTime, Value: "x"}}}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, config); err == nil {
+		t.Error("expected NoAttributes with extra signed attributes to fail")
+	}
+}
+
+func BenchmarkSignFromReset(b *testing.B) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		b.Fatal(err)
+	}
+	small := make([]byte, 4096)
+	b.SetBytes(int64(len(small)))
+	toBeSigned := NewEncoder(ioutil.Discard)
+	if err := toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		b.Fatalf("Cannot add signer: %s", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		toBeSigned.Reset(ioutil.Discard, true)
+		if err = toBeSigned.SignFrom(bytes.NewReader(small), len(small)); err != nil {
+			b.Fatalf("Cannot finish signing data: %s", err)
+		}
+	}
+}
+
+func TestEncoder_Reset(t *testing.T) {
+	first, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(signed, content []byte, cert *x509.Certificate) {
+		t.Helper()
+		p7, err := Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p7.Content, content) {
+			t.Errorf("expected content %q, got %q", content, p7.Content)
+		}
+		if len(p7.Signers) != 1 || len(p7.Certificates) != 1 || !p7.Certificates[0].Equal(cert) {
+			t.Errorf("expected a single signer with its certificate, got %d signers and %d certificates", len(p7.Signers), len(p7.Certificates))
+		}
+		if err = p7.Verify(); err != nil {
+			t.Error(err)
+		}
+	}
+	contents := [][]byte{
+		bytes.Repeat([]byte("Hello World "), 1000),
+		[]byte("Hello Again"),
+		bytes.Repeat([]byte("Goodbye World "), 1000),
+	}
+
+	buf := new(bytes.Buffer)
+	toBeSigned := NewPEMEncoder(buf)
+	if err = toBeSigned.AddSigner(first.Certificate, first.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned.Detach()
+	if err = toBeSigned.SignFrom(bytes.NewReader(contents[0]), len(contents[0])); err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range contents {
+		keep := i < 2
+		buf = new(bytes.Buffer)
+		toBeSigned.Reset(buf, keep)
+		cert := first
+		if !keep {
+			cert = second
+			if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(buf.Bytes())
+		if block == nil {
+			t.Fatalf("message %d: expected PEM output", i)
+		}
+		verify(block.Bytes, content, cert.Certificate)
+	}
+}
+
+func TestEncoder_SignFromReaderAt(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 1<<20)
+	if _, err = rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	for _, detached := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		toBeSigned := NewEncoder(buf)
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if detached {
+			toBeSigned.Detach()
+		}
+		r := &countingReaderAt{r: bytes.NewReader(content)}
+		if err = toBeSigned.SignFromReaderAt(r, len(content)); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		// read once for the digest, and once more to be written unless detached
+		reads := int64(2 * len(content))
+		if detached {
+			reads = int64(len(content))
+		}
+		if n := atomic.LoadInt64(&r.n); n != reads {
+			t.Errorf("detached %v: expected %d bytes read, got %d", detached, reads, n)
+		}
+		dest := new(bytes.Buffer)
+		p7 := NewDecoder(buf)
+		if detached {
+			err = p7.VerifyExternalTo(dest, bytes.NewReader(content))
+		} else {
+			err = p7.VerifyTo(dest)
+		}
+		if err != nil {
+			t.Fatalf("detached %v: %+v", detached, err)
+		}
+		if !bytes.Equal(content, dest.Bytes()) {
+			t.Errorf("detached %v: content does not match", detached)
+		}
+	}
+}
+
+// countingReaderAt counts the bytes read from r
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func TestDecoder_EmptyCertificates(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{OmitCertificate: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	signed := buf.Bytes()
+	if !bytes.Contains(signed, []byte{0xa0, 0x00, 0x31}) {
+		t.Fatalf("expected an empty certificates SET followed by signer infos in %x", signed)
+	}
+
+	p7, err := Parse(signed)
+	if err != nil {
+		t.Fatalf("Parse failed on empty certificates: %v", err)
+	}
+	if len(p7.Certificates) != 0 {
+		t.Errorf("expected no certificates, got %d", len(p7.Certificates))
+	}
+	p7.Certificates = []*x509.Certificate{cert.Certificate}
+	if err = p7.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	// the streaming decoder parses the empty SET and finds no certificate
+	p7 = NewDecoder(bytes.NewReader(signed))
+	if err = p7.VerifyTo(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "No certificate for signer") {
+		t.Errorf("expected a missing certificate error, got %v", err)
+	}
+}
+
+func TestEncodeDegenerateCertificates(t *testing.T) {
+	var certs [][]byte
+	for i := 0; i < 3; i++ {
+		cert, err := createTestCertificate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert.Certificate.Raw)
+	}
+	buf := new(bytes.Buffer)
+	i := 0
+	err := EncodeDegenerateCertificates(buf, func() ([]byte, error) {
+		if i == len(certs) {
+			return nil, io.EOF
+		}
+		i++
+		return certs[i-1], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Certificates) != len(certs) {
+		t.Fatalf("expected %d certificates, got %d", len(certs), len(p7.Certificates))
+	}
+	for i, cert := range p7.Certificates {
+		if !bytes.Equal(cert.Raw, certs[i]) {
+			t.Errorf("certificate %d does not match", i)
+		}
+	}
+	if len(p7.Signers) != 0 || len(p7.Content) != 0 {
+		t.Error("expected no signers and no content")
+	}
+
+	err = EncodeDegenerateCertificates(ioutil.Discard, func() ([]byte, error) {
+		return []byte("not a certificate"), nil
+	})
+	if err == nil {
+		t.Error("expected error for malformed certificate")
+	}
+}
+
+func TestEncoder_Detach(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	for _, detached := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		toBeSigned := NewEncoder(buf)
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if detached {
+			toBeSigned.Detach()
+		}
+		if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		signed := buf.Bytes()
+		if bytes.Contains(signed, content) == detached {
+			t.Errorf("detached %v: unexpected content presence in output", detached)
+		}
+		p7, err := Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eContent := p7.raw.(signedData).ContentInfo.Content.FullBytes
+		if detached && len(eContent) != 0 {
+			t.Errorf("expected eContent to be absent, got %x", eContent)
+		}
+		if !detached && len(eContent) == 0 {
+			t.Error("expected eContent to be present")
+		}
+		p7.Content = content
+		if err = p7.Verify(); err != nil {
+			t.Errorf("detached %v: %v", detached, err)
+		}
+	}
+}
+
+func TestDecoder_VerifyToDetached(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	toBeSigned, err := NewSignedData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned.Detach()
+	der, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	if err = enc.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	enc.Detach()
+	if err = enc.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	for name, signed := range map[string][]byte{"DER": der, "BER": buf.Bytes()} {
+		if err = NewDecoder(bytes.NewReader(signed)).VerifyTo(ioutil.Discard); !xerrors.Is(err, ErrDetachedContent) {
+			t.Errorf("%s: expected ErrDetachedContent, got %v", name, err)
+		}
+		if err = NewDecoder(bytes.NewReader(signed)).VerifyExternalTo(ioutil.Discard, bytes.NewReader(content)); err != nil {
+			t.Errorf("%s: VerifyExternalTo: %v", name, err)
+		}
+	}
+	// the message is cut right after the header of the certificates, which
+	// must not be read once the encapContentInfo turns out to have no content
+	encap := []byte{0x30, 0x0b, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x01}
+	i := bytes.Index(der, encap) + len(encap)
+	if i < len(encap) || der[i] != 0xa0 {
+		t.Fatalf("unexpected layout of the detached message: %x", der)
+	}
+	if err = NewDecoder(bytes.NewReader(der[:i+4])).VerifyTo(ioutil.Discard); !xerrors.Is(err, ErrDetachedContent) {
+		t.Errorf("expected ErrDetachedContent before the certificates, got %v", err)
+	}
+}
+
+func TestDecoder_VerifyToNoSigners(t *testing.T) {
+	toBeSigned, err := NewSignedData([]byte("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := toBeSigned.sd
+	// signedData without the required signerInfos SET
+	truncated, err := asn1.Marshal(struct {
+		Version                    int
+		DigestAlgorithmIdentifiers []pkix.AlgorithmIdentifier `asn1:"set"`
+		ContentInfo                contentInfo
+	}{sd.Version, sd.DigestAlgorithmIdentifiers, sd.ContentInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: truncated},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := new(bytes.Buffer)
+	if err = NewDecoder(bytes.NewReader(missing)).VerifyTo(dest); err == nil {
+		t.Errorf("missing signerInfos: expected an error, got content %q", dest.Bytes())
+	}
+	empty, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x30, 0x00}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = NewDecoder(bytes.NewReader(empty)).VerifyTo(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "before a required field") {
+		t.Errorf("empty signedData: expected the required field error, got %v", err)
+	}
+	if err = NewDecoder(bytes.NewReader(unsigned)).VerifyTo(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "Message has no signers") {
+		t.Errorf("no signers: expected the no signers error, got %v", err)
+	}
+	if err = NewDecoder(bytes.NewReader(unsigned)).VerifyExternalTo(ioutil.Discard, strings.NewReader("Hello")); err == nil || !strings.Contains(err.Error(), "Message has no signers") {
+		t.Errorf("no signers: VerifyExternalTo: expected the no signers error, got %v", err)
+	}
+}
+
+// failingReader fails the test when any content is read from it
+type failingReader struct {
+	t *testing.T
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	r.t.Error("external content was read after abort")
+	return 0, io.EOF
+}
+
+func TestDecoder_OnSignerInfoAbort(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	errUntrusted := xerrors.New("untrusted signer")
+	var seen []*x509.Certificate
+	abort := func(index int, sid IssuerAndSerial, signer *x509.Certificate) error {
+		if sid.SerialNumber.Cmp(cert.Certificate.SerialNumber) != 0 || !bytes.Equal(sid.RawIssuer, cert.Certificate.RawIssuer) {
+			t.Errorf("unexpected signer identifier %v", sid)
+		}
+		seen = append(seen, signer)
+		return errUntrusted
+	}
+	for _, detached := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		toBeSigned := NewEncoder(buf)
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if detached {
+			toBeSigned.Detach()
+		}
+		if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+			t.Fatal(err)
+		}
+		p7 := NewDecoder(bytes.NewReader(buf.Bytes()))
+		p7.OnSignerInfo = abort
+		dest := new(bytes.Buffer)
+		if detached {
+			err = p7.VerifyExternalTo(dest, failingReader{t})
+		} else {
+			err = p7.VerifyTo(dest)
+		}
+		if err != errUntrusted {
+			t.Errorf("detached %v: expected callback error, got %v", detached, err)
+		}
+		if dest.Len() != 0 {
+			t.Errorf("detached %v: content of a rejected signer was written: %q", detached, dest.Bytes())
+		}
+	}
+	if len(seen) != 2 || !seen[0].Equal(cert.Certificate) || !seen[1].Equal(cert.Certificate) {
+		t.Errorf("expected the signer certificate to be passed, got %v", seen)
+	}
+}
+
+func TestDecoder_OnSignerInfoHeldContent(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("Hello World "), 1000)
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	accept := func(int, IssuerAndSerial, *x509.Certificate) error { return nil }
+
+	p7 := NewDecoder(bytes.NewReader(buf.Bytes()))
+	p7.OnSignerInfo = accept
+	dest := new(bytes.Buffer)
+	if err = p7.VerifyTo(dest); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest.Bytes(), content) {
+		t.Error("content of an accepted signer was not written")
+	}
+
+	p7 = NewDecoder(bytes.NewReader(buf.Bytes()))
+	p7.OnSignerInfo = accept
+	p7.MaxHeldContent = int64(len(content) - 1)
+	dest.Reset()
+	if err = p7.VerifyTo(dest); !xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected ErrContentTooLarge above MaxHeldContent, got %v", err)
+	}
+	if dest.Len() != 0 {
+		t.Errorf("%d bytes written before the signers were accepted", dest.Len())
+	}
+}
+
+func TestDecoder_MaxContentFragments(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte{'x'}, 100)
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	// split the content into one byte fragments of an indefinite length
+	// constructed OCTET STRING
+	fragmented := []byte{0x24, 0x80}
+	for _, b := range content {
+		fragmented = append(fragmented, 0x04, 0x01, b)
+	}
+	fragmented = append(fragmented, 0x00, 0x00)
+	primitive := append([]byte{0x04, byte(len(content))}, content...)
+	if !bytes.Contains(buf.Bytes(), primitive) {
+		t.Fatal("content OCTET STRING not found")
+	}
+	signed := bytes.Replace(buf.Bytes(), primitive, fragmented, 1)
+
+	dest := new(bytes.Buffer)
+	p7 := NewDecoder(bytes.NewReader(signed))
+	p7.MaxContentFragments = len(content)
+	if err = p7.VerifyTo(dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(dest.Bytes(), content) {
+		t.Error("content mismatch")
+	}
+	p7 = NewDecoder(bytes.NewReader(signed))
+	p7.MaxContentFragments = len(content) - 1
+	if err = p7.VerifyTo(ioutil.Discard); !xerrors.Is(err, ErrTooManyFragments) {
+		t.Errorf("expected ErrTooManyFragments, got %v", err)
+	}
+}
+
+func TestEncoder_Flush(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("buffered content "), 512)
+	buf := new(bytes.Buffer)
+	bw := bufio.NewWriterSize(buf, 4096)
+	toBeSigned := NewEncoder(bw)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	if bw.Buffered() == 0 {
+		t.Fatal("expected buffered output before flush")
+	}
+	if err = toBeSigned.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if bw.Buffered() != 0 {
+		t.Errorf("%d bytes left in buffer after flush", bw.Buffered())
+	}
+	signed := buf.Bytes()
+	if !bytes.HasSuffix(signed, []byte{0, 0, 0, 0, 0, 0}) {
+		t.Errorf("output is missing end-of-contents octets: %x", signed[len(signed)-6:])
+	}
+	dest := new(bytes.Buffer)
+	if err = NewDecoder(bytes.NewReader(signed)).VerifyTo(dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(content, dest.Bytes()) {
+		t.Error("content does not match")
+	}
+	if err = NewEncoder(ioutil.Discard).Flush(); err != nil {
+		t.Errorf("unexpected error flushing a plain writer: %v", err)
+	}
+}
+
+func TestEncoder_SignFile(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "pkcs7-signfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	content := make([]byte, 5<<20)
+	if _, err = rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFile(f.Name()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	dest := new(bytes.Buffer)
+	if err = NewDecoder(buf).VerifyTo(dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(content, dest.Bytes()) {
+		t.Error("content does not match")
+	}
+	if err = NewEncoder(ioutil.Discard).SignFile(f.Name() + ".missing"); !xerrors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestVerifyData(t *testing.T) {
+	_, err := os.Stat("testdata")
+	if err != nil {
+		t.Skip("checking testdata: ", err)
+	}
+	if err = filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
+		if !strings.HasSuffix(path, ".cms") || info.IsDir() || err != nil {
+			return err
+		}
+		fp, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			p7 := NewDecoder(fp)
+			buf := new(bytes.Buffer)
+			if err = p7.VerifyTo(buf); err != nil {
+				t.Errorf("%+v", err)
+				return
+			}
+			signer := p7.GetOnlySigner()
+			if signer == nil || len(signer.Raw) == 0 {
+				t.Errorf("no signer for %s", path)
+				return
+			}
+			fn := strings.TrimSuffix(filepath.Base(path), ".cms")
+			t.Logf("verify success on %s", fn)
+			ioutil.WriteFile(filepath.Join("fixtures", fn+".bin"), buf.Bytes(), os.ModePerm)
+		})
+		return nil
+	}); err != nil {
+		t.Errorf("%+v", err)
+	}
+}
+
+func TestEnvelopeEncoder_EncryptFrom(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 4<<20+123)
+	if _, err = rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := NewEnvelopeEncoder(&buf, []*x509.Certificate{cert.Certificate}, EncryptionAlgorithmAES128GCM, EnvelopeConfig{})
+	if err = enc.EncryptFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := p7.Decrypt(cert.Certificate, cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, content) {
+		t.Error("decrypted content does not match the original")
+	}
+	if typ := p7.Type(); typ != MessageTypeAuthEnvelopedData {
+		t.Errorf("expected %v, got %v", MessageTypeAuthEnvelopedData, typ)
+	}
+
+	buf.Reset()
+	enc.config.AuthenticatedAttributes = []Attribute{{Type: asn1.ObjectIdentifier{1, 2, 3, 4, 6}, Value: "transaction 42"}}
+	if err = enc.EncryptFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	if p7, err = Parse(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err = p7.Decrypt(cert.Certificate, cert.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, content) {
+		t.Error("decrypted content does not match the original")
+	}
+	tampered := bytes.Replace(buf.Bytes(), []byte("transaction 42"), []byte("transaction 43"), 1)
+	if p7, err = Parse(tampered); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p7.Decrypt(cert.Certificate, cert.PrivateKey); err == nil {
+		t.Error("expected tampered authenticated attributes to fail decryption")
+	}
+
+	err = enc.EncryptFrom(bytes.NewReader(content[:10]), 11)
+	if !xerrors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected %v for short content, got %v", io.ErrUnexpectedEOF, err)
+	}
+	if err = enc.EncryptFrom(bytes.NewReader(nil), -1); err == nil || xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected an invalid size error, got %v", err)
+	}
+	enc.MaxBufferSize = 1 << 20
+	if err = enc.EncryptFrom(bytes.NewReader(content), len(content)); !xerrors.Is(err, ErrContentTooLarge) {
+		t.Errorf("expected ErrContentTooLarge above MaxBufferSize, got %v", err)
+	}
+	buf.Reset()
+	if err = enc.EncryptFrom(bytes.NewReader(content[:1<<20]), 1<<20); err != nil {
+		t.Errorf("expected content of MaxBufferSize to be accepted, got %v", err)
+	}
+	enc = NewEnvelopeEncoder(&buf, []*x509.Certificate{cert.Certificate}, EncryptionAlgorithmAES128CBC, EnvelopeConfig{})
+	if err = enc.EncryptFrom(bytes.NewReader(content), len(content)); err != ErrUnsupportedEncryptionAlgorithm {
+		t.Errorf("expected %v, got %v", ErrUnsupportedEncryptionAlgorithm, err)
+	}
+}
+
+func TestDecoder_VerifyToSigners(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned.Detach()
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signed := buf.Bytes()
+
+	pr, pw := io.Pipe()
+	signers := make(chan *x509.Certificate)
+	dest := new(bytes.Buffer)
+	done := make(chan error, 1)
+	go func() {
+		done <- NewDecoder(bytes.NewReader(signed)).VerifyExternalToSigners(dest, pr, signers)
+	}()
+	// no content is written into the pipe until the signer is received
+	signer := <-signers
+	if signer == nil || !bytes.Equal(signer.Raw, cert.Certificate.Raw) {
+		t.Fatal("unexpected signer certificate")
+	}
+	if _, ok := <-signers; ok {
+		t.Error("expected the channel to be closed after the signers")
+	}
+	if _, err = pw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+	if err = <-done; err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(dest.Bytes(), content) {
+		t.Error("content does not match")
+	}
+
+	// embedded content
+	buf.Reset()
+	toBeSigned = NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signers = make(chan *x509.Certificate, 1)
+	if err = NewDecoder(bytes.NewReader(buf.Bytes())).VerifyToSigners(ioutil.Discard, signers); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var n int
+	for range signers {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected 1 signer certificate, got %d", n)
+	}
+
+	// the channel is closed when decoding fails
+	signers = make(chan *x509.Certificate)
+	if err = NewDecoder(bytes.NewReader(buf.Bytes()[:20])).VerifyToSigners(ioutil.Discard, signers); err == nil {
+		t.Error("expected truncated message to fail")
+	}
+	if _, ok := <-signers; ok {
+		t.Error("expected the channel to be closed")
+	}
+}
+
+func TestEncoder_ContentDigest(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Hello World")
+	buf := new(bytes.Buffer)
+	toBeSigned := NewEncoder(buf)
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{DigestAlgorithm: crypto.SHA512}); err != nil {
+		t.Fatal(err)
+	}
+	digests, err := toBeSigned.ContentDigest(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 2 {
+		t.Fatalf("expected 2 digests, got %d", len(digests))
+	}
+	expected := sha256.Sum256(content)
+	if !bytes.Equal(digests[crypto.SHA256], expected[:]) {
+		t.Errorf("expected SHA-256 digest %x, got %x", expected, digests[crypto.SHA256])
+	}
+	if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, signer := range p7.Signers {
+		hash, err := getHashForOID(signer.DigestAlgorithm.Algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var messageDigest []byte
+		if err = unmarshalAttribute(signer.AuthenticatedAttributes, oidAttributeMessageDigest, &messageDigest); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(messageDigest, digests[hash]) {
+			t.Errorf("signer %d: message digest does not match the precomputed %v digest", i, hash)
+		}
+	}
+}
+
+func TestEncoder_EstimatedSize(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert, ecKey, err := createTestECCertificate(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("Hello World"), 1000)
+	for _, tc := range []struct {
+		name     string
+		pem      bool
+		detached bool
+		ecdsa    bool
+	}{
+		{name: "embedded"},
+		{name: "detached", detached: true},
+		{name: "pem", pem: true},
+		{name: "ecdsa", ecdsa: true},
+	} {
+		buf := new(bytes.Buffer)
+		toBeSigned := NewEncoder(buf)
+		if tc.pem {
+			toBeSigned = NewPEMEncoder(buf)
+		}
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{DigestAlgorithm: crypto.SHA384}); err != nil {
+			t.Fatal(err)
+		}
+		if tc.ecdsa {
+			if err = toBeSigned.AddSigner(ecCert, ecKey, SignerInfoConfig{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tc.detached {
+			toBeSigned.Detach()
+		}
+		estimate, err := toBeSigned.EstimatedSize(len(content))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err = toBeSigned.SignFrom(bytes.NewReader(content), len(content)); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		actual := int64(buf.Len())
+		if tc.ecdsa {
+			// the ECDSA signature is at most a few bytes shorter
+			if actual > estimate || estimate-actual > 8 {
+				t.Errorf("%s: estimated %d bytes, wrote %d", tc.name, estimate, actual)
+			}
+		} else if actual != estimate {
+			t.Errorf("%s: estimated %d bytes, wrote %d", tc.name, estimate, actual)
+		}
 	}
 }
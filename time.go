@@ -0,0 +1,130 @@
+package pkcs7
+
+import (
+	"encoding/asn1"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// SigningTime returns the time in the signing-time attribute of the signer at
+// index. Both UTCTime and GeneralizedTime are accepted, the latter with or
+// without fractional seconds.
+func (p7 *PKCS7) SigningTime(index int) (time.Time, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var raw asn1.RawValue
+	if err = unmarshalAttribute(signer.AuthenticatedAttributes, oidAttributeSigningTime, &raw); err != nil {
+		return time.Time{}, xerrors.Errorf("pkcs7: reading signing time: %w", err)
+	}
+	return parseTime(raw)
+}
+
+// parseTime decodes a UTCTime or GeneralizedTime
+func parseTime(raw asn1.RawValue) (time.Time, error) {
+	if raw.Class == asn1.ClassUniversal && raw.Tag == asn1.TagGeneralizedTime {
+		return parseGeneralizedTime(raw.Bytes)
+	}
+	var t time.Time
+	if _, err := asn1.Unmarshal(raw.FullBytes, &t); err != nil {
+		return time.Time{}, xerrors.Errorf("pkcs7: parsing time: %w", err)
+	}
+	return t, nil
+}
+
+// parseGeneralizedTime decodes YYYYMMDDHH[MM[SS]][(.|,)fraction](Z|+hhmm|-hhmm).
+// Unlike encoding/asn1 it accepts the BER forms, i.e. trailing zeros in the
+// fraction, a comma separator and omitted minutes or seconds, as some time
+// stamping authorities and signers produce them. Fractions beyond nanoseconds
+// are truncated. The local time form without a zone is rejected, as its
+// meaning depends on the signer.
+func parseGeneralizedTime(b []byte) (time.Time, error) {
+	s := string(b)
+	fail := func() (time.Time, error) {
+		return time.Time{}, xerrors.Errorf("pkcs7: invalid GeneralizedTime %q", s)
+	}
+	pos := 0
+	digits := func(n int) (int, bool) {
+		if len(b)-pos < n {
+			return 0, false
+		}
+		v := 0
+		for _, c := range b[pos : pos+n] {
+			if c < '0' || c > '9' {
+				return 0, false
+			}
+			v = v*10 + int(c-'0')
+		}
+		pos += n
+		return v, true
+	}
+	isDigit := func() bool {
+		return pos < len(b) && b[pos] >= '0' && b[pos] <= '9'
+	}
+	year, ok1 := digits(4)
+	month, ok2 := digits(2)
+	day, ok3 := digits(2)
+	hour, ok4 := digits(2)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return fail()
+	}
+	var minute, second, nsec int
+	var ok bool
+	if isDigit() {
+		if minute, ok = digits(2); !ok {
+			return fail()
+		}
+		if isDigit() {
+			if second, ok = digits(2); !ok {
+				return fail()
+			}
+		}
+	}
+	if pos < len(b) && (b[pos] == '.' || b[pos] == ',') {
+		pos++
+		if !isDigit() {
+			return fail()
+		}
+		scale := int(time.Second)
+		for ; isDigit(); pos++ {
+			scale /= 10
+			nsec += int(b[pos]-'0') * scale
+		}
+	}
+	if month < 1 || month > 12 || day < 1 || hour > 23 || minute > 59 || second > 59 {
+		return fail()
+	}
+	if pos >= len(b) {
+		return fail()
+	}
+	loc := time.UTC
+	switch b[pos] {
+	case 'Z':
+		pos++
+	case '+', '-':
+		sign := 1
+		if b[pos] == '-' {
+			sign = -1
+		}
+		pos++
+		zoneHour, ok1 := digits(2)
+		zoneMinute, ok2 := digits(2)
+		if !ok1 || !ok2 || zoneHour > 23 || zoneMinute > 59 {
+			return fail()
+		}
+		loc = time.FixedZone("", sign*(zoneHour*3600+zoneMinute*60))
+	default:
+		return fail()
+	}
+	if pos != len(b) {
+		return fail()
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, nsec, loc)
+	if t.Day() != day {
+		// e.g. February 30th
+		return fail()
+	}
+	return t, nil
+}
@@ -0,0 +1,129 @@
+package pkcs7
+
+import (
+	"crypto"
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+func TestParseGeneralizedTime(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"20240229123456Z", time.Date(2024, 2, 29, 12, 34, 56, 0, time.UTC)},
+		{"20240229123456.5Z", time.Date(2024, 2, 29, 12, 34, 56, 500000000, time.UTC)},
+		{"20240229123456.500Z", time.Date(2024, 2, 29, 12, 34, 56, 500000000, time.UTC)},
+		{"20240229123456,123456789123Z", time.Date(2024, 2, 29, 12, 34, 56, 123456789, time.UTC)},
+		{"202402291234Z", time.Date(2024, 2, 29, 12, 34, 0, 0, time.UTC)},
+		{"2024022912Z", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"20240229123456.25+0130", time.Date(2024, 2, 29, 11, 4, 56, 250000000, time.UTC)},
+	} {
+		got, err := parseGeneralizedTime([]byte(tc.in))
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.in, tc.want, got)
+		}
+	}
+	for _, in := range []string{
+		"",
+		"20240229123456",
+		"20240229123456.Z",
+		"20230229123456Z",
+		"20241329123456Z",
+		"20240229123456Z0",
+		"20240229123456+01",
+		"2024022912345Z",
+	} {
+		if _, err := parseGeneralizedTime([]byte(in)); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestSigningTimeFractional(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned, err := NewSignedData([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := SignerInfoConfig{
+		SkipSigningTime: true,
+		ExtraSignedAttributes: []Attribute{{
+			Type:  oidAttributeSigningTime,
+			Value: asn1.RawValue{Tag: asn1.TagGeneralizedTime, Bytes: []byte("20240229123456.750Z")},
+		}},
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p7.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	signingTime, err := p7.SigningTime(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 2, 29, 12, 34, 56, 750000000, time.UTC); !signingTime.Equal(want) {
+		t.Errorf("expected signing time %v, got %v", want, signingTime)
+	}
+}
+
+func TestVerifyTimestampFractional(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tsa, err := createTestTSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBeSigned, err := NewSignedData([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	h := crypto.SHA256.New()
+	h.Write(toBeSigned.sd.SignerInfos[0].EncryptedDigest)
+	token, err := createTestTimestampTokenAt(tsa, h.Sum(nil), "20240229123456.120Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := &attributes{}
+	unsigned.Add(oidAttributeTimeStampToken, asn1.RawValue{FullBytes: token})
+	if toBeSigned.sd.SignerInfos[0].UnauthenticatedAttributes, err = unsigned.ForMarshaling(); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := toBeSigned.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := p7.VerifyTimestamp(0, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := time.Date(2024, 2, 29, 12, 34, 56, 120000000, time.UTC); !ts.Equal(want) {
+		t.Errorf("expected timestamp %v, got %v", want, ts)
+	}
+}
@@ -0,0 +1,118 @@
+package pkcs7
+
+import (
+	"crypto/hmac"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	oidTSTInfo                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttributeTimeStampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+)
+
+// ErrTimestampMismatch is returned when the message imprint of a timestamp
+// token does not match the signature it is attached to
+var ErrTimestampMismatch = xerrors.New("pkcs7: timestamp message imprint does not match signature")
+
+// ErrNoTimestamp is returned when a signer carries no timestamp token
+var ErrNoTimestamp = xerrors.New("pkcs7: signer has no timestamp token")
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        asn1.RawValue
+	Accuracy       asn1.RawValue `asn1:"optional"`
+	Ordering       bool          `asn1:"optional,default:false"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// VerifyTimestamp checks the RFC 3161 timestamp token found in the unsigned
+// attributes of the signer at index and returns the time it asserts. The
+// token signature is verified and its message imprint must match the digest
+// of the signature value. If roots is not nil, the TSA certificate must also
+// chain up to it and be valid for time stamping at the asserted time.
+func (p7 *PKCS7) VerifyTimestamp(index int, roots *x509.CertPool) (time.Time, error) {
+	signer, err := p7.signer(index)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var token []byte
+	for _, attr := range signer.UnauthenticatedAttributes {
+		if attr.Type.Equal(oidAttributeTimeStampToken) {
+			token = attr.Value.Bytes
+			break
+		}
+	}
+	if len(token) == 0 {
+		return time.Time{}, ErrNoTimestamp
+	}
+	tsp, err := Parse(token)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("pkcs7: parsing timestamp token: %w", err)
+	}
+	sd, ok := tsp.raw.(signedData)
+	if !ok {
+		return time.Time{}, xerrors.New("pkcs7: timestamp token is not signed data")
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidTSTInfo) {
+		return time.Time{}, xerrors.Errorf("pkcs7: unexpected timestamp token content type %v", sd.ContentInfo.ContentType)
+	}
+	tsaCerts, err := tsp.verify()
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("pkcs7: verifying timestamp token: %w", err)
+	}
+	var info tstInfo
+	if _, err = asn1.Unmarshal(tsp.Content, &info); err != nil {
+		return time.Time{}, xerrors.Errorf("pkcs7: parsing TSTInfo: %w", err)
+	}
+	if info.GenTime.Class != asn1.ClassUniversal || info.GenTime.Tag != asn1.TagGeneralizedTime {
+		return time.Time{}, xerrors.New("pkcs7: TSTInfo genTime is not a GeneralizedTime")
+	}
+	genTime, err := parseGeneralizedTime(info.GenTime.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	hash, err := getHashForOID(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return time.Time{}, err
+	}
+	imprint, err := p7.TimestampImprint(index, hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !hmac.Equal(imprint, info.MessageImprint.HashedMessage) {
+		return time.Time{}, ErrTimestampMismatch
+	}
+	if roots != nil {
+		tsaCert := tsaCerts[0]
+		intermediates := x509.NewCertPool()
+		for _, cert := range tsp.Certificates {
+			intermediates.AddCert(cert)
+		}
+		_, err = tsaCert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   genTime,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		})
+		if err != nil {
+			return time.Time{}, xerrors.Errorf("pkcs7: verifying TSA certificate: %w", err)
+		}
+	}
+	return genTime, nil
+}
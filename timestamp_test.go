@@ -0,0 +1,164 @@
+package pkcs7
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// createTestTSA creates a root and a time-stamping certificate issued by it
+func createTestTSA() (*x509.Certificate, *certKeyPair, error) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test TSA Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &rootTemplate, &rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	tsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, nil, err
+	}
+	tsaTemplate := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, &tsaTemplate, root, tsaKey.Public(), rootKey); err != nil {
+		return nil, nil, err
+	}
+	tsa, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, &certKeyPair{Certificate: tsa, PrivateKey: tsaKey}, nil
+}
+
+// createTestTimestampToken creates a timestamp token over the imprint
+func createTestTimestampToken(tsa *certKeyPair, imprint []byte, genTime time.Time) ([]byte, error) {
+	return createTestTimestampTokenAt(tsa, imprint, genTime.UTC().Format("20060102150405Z"))
+}
+
+// createTestTimestampTokenAt creates a timestamp token over the imprint with
+// genTime encoded verbatim as GeneralizedTime
+func createTestTimestampTokenAt(tsa *certKeyPair, imprint []byte, genTime string) ([]byte, error) {
+	info, err := asn1.Marshal(tstInfo{
+		Version: 1,
+		Policy:  asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			HashedMessage: imprint,
+		},
+		SerialNumber: big.NewInt(42),
+		GenTime:      asn1.RawValue{Tag: asn1.TagGeneralizedTime, Bytes: []byte(genTime)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	token, err := NewSignedDataWithContentType(info, oidTSTInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err = token.AddSigner(tsa.Certificate, tsa.PrivateKey, SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	return token.Finish()
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, tsa, err := createTestTSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genTime := time.Now().UTC().Truncate(time.Second)
+	sign := func(imprint func([]byte) []byte) *PKCS7 {
+		toBeSigned, err := NewSignedData([]byte("Hello World"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = toBeSigned.AddSigner(cert.Certificate, cert.PrivateKey, SignerInfoConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		h := crypto.SHA256.New()
+		h.Write(toBeSigned.sd.SignerInfos[0].EncryptedDigest)
+		token, err := createTestTimestampToken(tsa, imprint(h.Sum(nil)), genTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unsigned := &attributes{}
+		unsigned.Add(oidAttributeTimeStampToken, asn1.RawValue{FullBytes: token})
+		if toBeSigned.sd.SignerInfos[0].UnauthenticatedAttributes, err = unsigned.ForMarshaling(); err != nil {
+			t.Fatal(err)
+		}
+		signed, err := toBeSigned.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p7, err := Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p7
+	}
+
+	p7 := sign(func(imprint []byte) []byte { return imprint })
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	for _, pool := range []*x509.CertPool{nil, roots} {
+		ts, err := p7.VerifyTimestamp(0, pool)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !ts.Equal(genTime) {
+			t.Errorf("expected timestamp %v, got %v", genTime, ts)
+		}
+	}
+	if _, err = p7.VerifyTimestamp(0, x509.NewCertPool()); err == nil {
+		t.Error("expected TSA certificate verification to fail with an unrelated pool")
+	}
+
+	p7 = sign(func(imprint []byte) []byte {
+		imprint[0] ^= 0xff
+		return imprint
+	})
+	if _, err = p7.VerifyTimestamp(0, roots); !xerrors.Is(err, ErrTimestampMismatch) {
+		t.Errorf("expected timestamp mismatch, got %v", err)
+	}
+
+	fixture := UnmarshalTestFixture(SignedTestFixture)
+	if p7, err = Parse(fixture.Input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p7.VerifyTimestamp(0, nil); err != ErrNoTimestamp {
+		t.Errorf("expected no timestamp error, got %v", err)
+	}
+}
@@ -7,8 +7,14 @@ import (
 	"golang.org/x/xerrors"
 )
 
+// maxMetaLen is the longest identifier and length octets encodeMeta produces:
+// a high tag number takes up to 9 base 128 digits and a length up to 8 bytes
+const maxMetaLen = 1 + 9 + 1 + 8
+
 type berWriter struct {
 	io.Writer
+	// meta is the scratch buffer for encoding identifier and length octets
+	meta [maxMetaLen]byte
 }
 
 func base128IntLength(n int64) int {
@@ -40,7 +46,13 @@ func appendBase128Int(dst []byte, n int64) []byte {
 	return dst
 }
 
-func encodeMeta(class int, constructed bool, tag int, length int) (res []byte) {
+func encodeMeta(class int, constructed bool, tag int, length int) []byte {
+	return appendMeta(make([]byte, 0, maxMetaLen), class, constructed, tag, length)
+}
+
+// appendMeta appends the identifier and length octets to res, which does not
+// grow if it has room for maxMetaLen bytes
+func appendMeta(res []byte, class int, constructed bool, tag int, length int) []byte {
 	b := uint8(class) << 6
 	if constructed {
 		b |= 0x20
@@ -65,7 +77,7 @@ func encodeMeta(class int, constructed bool, tag int, length int) (res []byte) {
 	default:
 		res = append(res, byte(length))
 	}
-	return
+	return res
 }
 
 func (w *berWriter) object(val interface{}, params string) continuation {
@@ -99,7 +111,7 @@ func (w *berWriter) constructed(next continuation) continuation {
 
 func (w *berWriter) explicit(tag int, length int, next continuation) continuation {
 	return func(class int, constructed bool, _ int, _ int) (err error) {
-		if _, err = w.Write(encodeMeta(class, constructed, tag, length)); err != nil {
+		if _, err = w.Write(appendMeta(w.meta[:0], class, constructed, tag, length)); err != nil {
 			return
 		}
 		if err = w.writeBER(next); err != nil {
@@ -0,0 +1,78 @@
+package pkcs7
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestEncodeMeta(t *testing.T) {
+	for _, tc := range []struct {
+		class       int
+		constructed bool
+		tag         int
+		length      int
+	}{
+		{0, false, 4, 0},
+		{0, true, 16, 127},
+		{2, true, 0, 128},
+		{2, false, 30, 255},
+		{2, false, 31, 256},
+		{1, true, 127, 65535},
+		{3, false, 128, 70000},
+		{2, true, 16383, 1 << 24},
+		{0, false, 1 << 20, 5},
+	} {
+		expected, err := asn1.Marshal(asn1.RawValue{
+			Class:      tc.class,
+			Tag:        tc.tag,
+			IsCompound: tc.constructed,
+			Bytes:      make([]byte, tc.length),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = expected[:len(expected)-tc.length]
+		if res := encodeMeta(tc.class, tc.constructed, tc.tag, tc.length); !bytes.Equal(res, expected) {
+			t.Errorf("%+v: expected %x, got %x", tc, expected, res)
+		}
+		var scratch [maxMetaLen]byte
+		if res := appendMeta(scratch[:0], tc.class, tc.constructed, tc.tag, tc.length); !bytes.Equal(res, expected) || &res[0] != &scratch[0] {
+			t.Errorf("%+v: appendMeta did not encode %x in place", tc, expected)
+		}
+	}
+	if res := encodeMeta(2, true, 100, -1); !bytes.Equal(res, []byte{0xbf, 0x64, 0x80}) {
+		t.Errorf("unexpected indefinite length encoding %x", res)
+	}
+	var scratch [maxMetaLen]byte
+	if res := appendMeta(scratch[:0], 3, true, int(^uint(0)>>1), int(^uint(0)>>1)); len(res) > maxMetaLen {
+		t.Errorf("encoding of %d bytes exceeds maxMetaLen", len(res))
+	}
+}
+
+func BenchmarkAppendBase128Int(b *testing.B) {
+	var scratch [maxMetaLen]byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		appendBase128Int(scratch[:0], 1<<20)
+	}
+}
+
+func BenchmarkEncodeMeta(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeMeta(2, true, 1<<20, 70000)
+	}
+}
+
+func BenchmarkWriterMeta(b *testing.B) {
+	w := &berWriter{Writer: new(bytes.Buffer)}
+	cont := w.explicit(1<<20, 0, func(int, bool, int, int) error { return nil })
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Writer.(*bytes.Buffer).Reset()
+		if err := w.writeBER(cont); err != nil {
+			b.Fatal(err)
+		}
+	}
+}